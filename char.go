@@ -1,5 +1,10 @@
 package decexpr
 
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
 // === Массив для быстрой классификации символов ===
 type CharType int
 
@@ -12,7 +17,11 @@ const (
 	CharOperator
 	CharLeftParen
 	CharRightParen
+	CharLeftBracket
+	CharRightBracket
 	CharComma
+	CharSemicolon
+	CharQuote
 	CharEOF
 )
 
@@ -48,7 +57,12 @@ func init() {
 	charMap['.'] = CharDot
 	charMap['('] = CharLeftParen
 	charMap[')'] = CharRightParen
+	charMap['['] = CharLeftBracket
+	charMap[']'] = CharRightBracket
 	charMap[','] = CharComma
+	charMap[';'] = CharSemicolon
+	charMap['"'] = CharQuote
+	charMap['\''] = CharQuote
 
 	// Операторы
 	charMap['+'] = CharOperator
@@ -57,6 +71,13 @@ func init() {
 	charMap['/'] = CharOperator
 	charMap['%'] = CharOperator
 	charMap['^'] = CharOperator
+	charMap['!'] = CharOperator
+	charMap['='] = CharOperator
+	charMap['<'] = CharOperator
+	charMap['>'] = CharOperator
+	charMap['&'] = CharOperator
+	charMap['|'] = CharOperator
+	charMap['?'] = CharOperator
 }
 
 // === Быстрые проверки через массив ===
@@ -95,3 +116,24 @@ func isComma(ch byte) bool {
 func isValidChar(ch byte) bool {
 	return charMap[ch] != CharInvalid
 }
+
+// isIdentRune сообщает, может ли символ вне ASCII входить в идентификатор: буква - в любом месте,
+// комбинируемые знаки (Mn, Mc) и цифры (Nd) - после первого символа, как в "मूल्य" или "été" в форме NFD.
+func isIdentRune(r rune, first bool) bool {
+	return unicode.IsLetter(r) || !first && unicode.In(r, unicode.Mn, unicode.Mc, unicode.Nd)
+}
+
+// isIdentName проверяет, что строка - допустимое имя идентификатора без точек.
+func isIdentName(name string) bool {
+	for i, r := range name {
+		switch {
+		case r < utf8.RuneSelf && isLetter(byte(r)):
+		case r < utf8.RuneSelf && isDigit(byte(r)) && i > 0:
+		case r >= utf8.RuneSelf && isIdentRune(r, i == 0):
+		default:
+			return false
+		}
+	}
+
+	return name != ""
+}
@@ -0,0 +1,437 @@
+package decexpr
+
+import (
+	"slices"
+
+	pkgErrors "github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+)
+
+// Derivative возвращает производную выражения по переменной name: "price * (1 + rate)" по rate дает "price".
+// Результат упрощается и вычисляется с теми же переменными, что и исходное выражение.
+// Кусочно-постоянные функции (floor, ceil, round, trunc, sign) дают 0, производная min, max, clamp и if
+// выбирает ветвь через if. Для операторов сравнения, функций без известной производной и сценариев с let
+// возвращается ошибка; подвыражения, не зависящие от name, дают 0 без ограничений.
+func (p *Program) Derivative(name string) (*Program, error) {
+	if len(p.rpn) == 0 {
+		return p, nil
+	}
+
+	stmts, err := buildTree(p.rpn)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(stmts) > 1 {
+		return nil, pkgErrors.Errorf("derivative of a script with let is not supported, token %s",
+			stmts[0].item.location())
+	}
+
+	d := &deriver{eval: p.eval, name: name}
+
+	res, err := d.diff(stmts[0])
+	if err != nil {
+		return nil, err
+	}
+
+	res = p.eval.partial(res, nil, nil)
+
+	return p.eval.Compile(formatTree([]*node{res}, p.eval.locale()))
+}
+
+// Derivative разбирает выражение и возвращает текст его производной по переменной name.
+func (e *ExpressionEvaluator) Derivative(exp, name string) (string, error) {
+	p, err := e.Compile(exp)
+	if err != nil {
+		return "", err
+	}
+
+	res, err := p.Derivative(name)
+	if err != nil {
+		return "", err
+	}
+
+	return res.Source(), nil
+}
+
+// deriver строит дерево производной и упрощает его по ходу: 0 и 1 в сложении, умножении и степени
+// сокращаются, операции над литералами вычисляются.
+type deriver struct {
+	eval *ExpressionEvaluator
+	name string
+}
+
+func (d *deriver) diff(n *node) (*node, error) {
+	pos := n.item.Position
+
+	if !d.depends(n) {
+		return d.num(0, pos), nil
+	}
+
+	switch n.item.Type {
+	case TokenIdent:
+		return d.num(1, pos), nil
+	case TokenUnaryOperator:
+		if n.item.Literal != OpSub {
+			break
+		}
+
+		du, err := d.diff(n.args[0])
+		if err != nil {
+			return nil, err
+		}
+
+		return d.neg(du, pos), nil
+	case TokenPostfixOperator:
+		if n.item.Literal != OpPercent {
+			break
+		}
+
+		du, err := d.diff(n.args[0])
+		if err != nil {
+			return nil, err
+		}
+
+		return d.div(du, d.num(100, pos), pos), nil
+	case TokenOperator:
+		return d.operator(n)
+	case TokenFunction:
+		return d.function(n)
+	}
+
+	return nil, pkgErrors.Errorf("'%s' has no derivative, token %s", n.item.Literal, n.item.location())
+}
+
+func (d *deriver) operator(n *node) (*node, error) {
+	u, v, pos := n.args[0], n.args[1], n.item.Position
+
+	du, err := d.diff(u)
+	if err != nil {
+		return nil, err
+	}
+
+	dv, err := d.diff(v)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.item.Literal {
+	case OpAdd:
+		return d.add(du, dv, pos), nil
+	case OpSub:
+		return d.sub(du, dv, pos), nil
+	case OpMul:
+		return d.add(d.mul(du, v, pos), d.mul(u, dv, pos), pos), nil
+	case OpDiv:
+		if !d.depends(v) {
+			return d.div(du, v, pos), nil
+		}
+
+		return d.div(d.sub(d.mul(du, v, pos), d.mul(u, dv, pos), pos), d.pow(v, d.num(2, pos), pos), pos), nil
+	case OpPower:
+		// c * u ^ (c - 1) * u' для постоянного показателя, u ^ v * ln(u) * v' для постоянного основания,
+		// u ^ v * (v' * ln(u) + v * u' / u) в общем случае
+		switch {
+		case !d.depends(v):
+			return d.mul(d.mul(v, d.pow(u, d.sub(v, d.num(1, pos), pos), pos), pos), du, pos), nil
+		case !d.depends(u):
+			return d.mul(d.mul(n, d.call("ln", pos, u), pos), dv, pos), nil
+		default:
+			return d.mul(n, d.add(d.mul(dv, d.call("ln", pos, u), pos), d.div(d.mul(v, du, pos), u, pos), pos), pos), nil
+		}
+	}
+
+	return nil, pkgErrors.Errorf("operator %s has no derivative, token %s", n.item.Literal, n.item.location())
+}
+
+func (d *deriver) function(n *node) (*node, error) {
+	name, pos := n.item.Literal, n.item.Position
+
+	// функции вызывающего кода не дифференцируются, даже если названы как встроенные
+	if _, ok := d.eval.builtin(name); !ok {
+		return nil, pkgErrors.Errorf("function %s has no derivative, token %s", name, n.item.location())
+	}
+
+	args := n.args
+
+	switch name {
+	case "floor", "ceil", "round", "trunc", "sign":
+		return d.num(0, pos), nil
+	case "abs":
+		du, err := d.diff(args[0])
+		if err != nil {
+			return nil, err
+		}
+
+		return d.mul(d.call("sign", pos, args[0]), du, pos), nil
+	case "ln":
+		du, err := d.diff(args[0])
+		if err != nil {
+			return nil, err
+		}
+
+		return d.div(du, args[0], pos), nil
+	case "exp":
+		du, err := d.diff(args[0])
+		if err != nil {
+			return nil, err
+		}
+
+		return d.mul(n, du, pos), nil
+	case "sum", "avg":
+		res := d.num(0, pos)
+
+		for _, arg := range args {
+			da, err := d.diff(arg)
+			if err != nil {
+				return nil, err
+			}
+
+			res = d.add(res, da, pos)
+		}
+
+		if name == "avg" {
+			res = d.div(res, d.num(int64(len(args)), pos), pos)
+		}
+
+		return res, nil
+	case "min", "max":
+		return d.extremum(name, args, pos)
+	case "clamp":
+		x, lo, hi := args[0], args[1], args[2]
+
+		inner := d.cond(d.op(OpGt, x, hi, pos), hi, x, pos)
+
+		return d.diff(d.cond(d.op(OpLt, x, lo, pos), lo, inner, pos))
+	case lazyFunction:
+		da, err := d.diff(unwrapBranch(args[1]))
+		if err != nil {
+			return nil, err
+		}
+
+		db, err := d.diff(unwrapBranch(args[2]))
+		if err != nil {
+			return nil, err
+		}
+
+		return d.cond(args[0], da, db, pos), nil
+	case "markup":
+		// markup(c, f) = c * (1 + f)
+		return d.diff(d.op(OpMul, args[0], d.op(OpAdd, d.num(1, pos), args[1], pos), pos))
+	case "pct_change":
+		// pct_change(a, b) = (b - a) / abs(a)
+		return d.diff(d.op(OpDiv, d.op(OpSub, args[1], args[0], pos), d.call("abs", pos, args[0]), pos))
+	}
+
+	return nil, pkgErrors.Errorf("function %s has no derivative, token %s", name, n.item.location())
+}
+
+// extremum дифференцирует min и max: max(a, b, c) = if(a >= max(b, c), a', max(b, c)').
+func (d *deriver) extremum(name string, args []*node, pos int) (*node, error) {
+	if len(args) == 1 {
+		return d.diff(args[0])
+	}
+
+	rest := args[1]
+	if len(args) > 2 {
+		rest = d.call(name, pos, args[1:]...)
+	}
+
+	cmp := OpGe
+	if name == "min" {
+		cmp = OpLe
+	}
+
+	da, err := d.diff(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	drest, err := d.extremum(name, args[1:], pos)
+	if err != nil {
+		return nil, err
+	}
+
+	return d.cond(d.op(cmp, args[0], rest, pos), da, drest, pos), nil
+}
+
+// depends сообщает, зависит ли поддерево от переменной; параметр лямбды с тем же именем ее скрывает.
+func (d *deriver) depends(n *node) bool {
+	switch n.item.Type {
+	case TokenIdent:
+		return n.item.Literal == d.name
+	case TokenLambda:
+		if slices.Contains(n.params, d.name) {
+			return false
+		}
+	}
+
+	return slices.ContainsFunc(n.args, d.depends)
+}
+
+func (d *deriver) num(v int64, pos int) *node {
+	n, _ := constNode(NumberValue(decimal.NewFromInt(v)), pos)
+
+	return n
+}
+
+func (d *deriver) op(op Operator, a, b *node, pos int) *node {
+	return &node{
+		item: &RPNItem{Token: Token{Type: TokenOperator, Literal: op, Position: pos}, Priority: operatorPriority[op]},
+		args: []*node{a, b},
+	}
+}
+
+func (d *deriver) call(name string, pos int, args ...*node) *node {
+	return &node{
+		item: &RPNItem{Token: Token{Type: TokenFunction, Literal: name, Position: pos}, FuncArgCount: len(args)},
+		args: args,
+	}
+}
+
+// cond строит if(c, a, b); ветви оборачиваются в лямбды без параметров, как их разбирает парсер.
+func (d *deriver) cond(c, a, b *node, pos int) *node {
+	if a.isConst() && b.isConst() && a.item.Type == b.item.Type && a.item.Literal == b.item.Literal {
+		return a
+	}
+
+	branch := func(body *node) *node {
+		return &node{item: &RPNItem{Token: Token{Type: TokenLambda, Literal: "->", Position: pos}}, args: []*node{body}}
+	}
+
+	return d.call(lazyFunction, pos, c, branch(a), branch(b))
+}
+
+func (d *deriver) neg(a *node, pos int) *node {
+	switch {
+	case a.item.Type == TokenFloatNumber:
+		n, _ := constNode(NumberValue(a.item.Number.Neg()), pos)
+
+		return n
+	case a.item.Type == TokenUnaryOperator && a.item.Literal == OpSub:
+		return a.args[0]
+	}
+
+	return &node{item: &RPNItem{Token: Token{Type: TokenUnaryOperator, Literal: OpSub, Position: pos}}, args: []*node{a}}
+}
+
+func (d *deriver) add(a, b *node, pos int) *node {
+	switch {
+	case isNumber(a, 0):
+		return b
+	case isNumber(b, 0):
+		return a
+	case b.item.Type == TokenUnaryOperator && b.item.Literal == OpSub,
+		b.item.Type == TokenFloatNumber && b.item.Number.IsNegative():
+		return d.sub(a, d.neg(b, pos), pos)
+	case d.same(a, b):
+		return d.mul(d.num(2, pos), a, pos)
+	}
+
+	return d.fold(d.op(OpAdd, a, b, pos))
+}
+
+func (d *deriver) sub(a, b *node, pos int) *node {
+	switch {
+	case isNumber(b, 0):
+		return a
+	case isNumber(a, 0):
+		return d.neg(b, pos)
+	case b.item.Type == TokenUnaryOperator && b.item.Literal == OpSub,
+		b.item.Type == TokenFloatNumber && b.item.Number.IsNegative():
+		return d.add(a, d.neg(b, pos), pos)
+	case d.same(a, b):
+		return d.num(0, pos)
+	}
+
+	return d.fold(d.op(OpSub, a, b, pos))
+}
+
+func (d *deriver) mul(a, b *node, pos int) *node {
+	// литерал ставится первым множителем, чтобы соседние литералы перемножались: 3 * (2 * x) = 6 * x
+	if b.item.Type == TokenFloatNumber && a.item.Type != TokenFloatNumber {
+		a, b = b, a
+	}
+
+	switch {
+	case isNumber(a, 0) || isNumber(b, 0):
+		return d.num(0, pos)
+	case isNumber(a, 1):
+		return b
+	case isNumber(b, 1):
+		return a
+	case isNumber(a, -1):
+		return d.neg(b, pos)
+	case a.item.Type == TokenFloatNumber && b.item.Type == TokenOperator && b.item.Literal == OpMul &&
+		b.args[0].item.Type == TokenFloatNumber:
+		return d.mul(d.mul(a, b.args[0], pos), b.args[1], pos)
+	}
+
+	return d.fold(d.op(OpMul, a, b, pos))
+}
+
+func (d *deriver) div(a, b *node, pos int) *node {
+	switch {
+	case isNumber(a, 0):
+		return d.num(0, pos)
+	case isNumber(b, 1):
+		return a
+	case d.same(a, b):
+		return d.num(1, pos)
+	}
+
+	return d.fold(d.op(OpDiv, a, b, pos))
+}
+
+func (d *deriver) pow(a, b *node, pos int) *node {
+	switch {
+	case isNumber(b, 0):
+		return d.num(1, pos)
+	case isNumber(b, 1):
+		return a
+	}
+
+	return d.fold(d.op(OpPower, a, b, pos))
+}
+
+// fold вычисляет операцию над литералами; ошибка, например деление на 0, остается до вычисления.
+func (d *deriver) fold(n *node) *node {
+	if !d.eval.foldable(n) {
+		return n
+	}
+
+	value, err := d.eval.evalNode(n)
+	if err != nil {
+		return n
+	}
+
+	if c, ok := constNode(value, n.item.Position); ok {
+		return c
+	}
+
+	return n
+}
+
+// same сообщает, совпадают ли поддеревья по записи.
+func (d *deriver) same(a, b *node) bool {
+	locale := d.eval.locale()
+
+	return formatTree([]*node{a}, locale) == formatTree([]*node{b}, locale)
+}
+
+// unwrapBranch возвращает тело ветви if, разобранной как лямбда без параметров.
+func unwrapBranch(n *node) *node {
+	if n.item.Type == TokenLambda && len(n.params) == 0 {
+		return n.args[0]
+	}
+
+	return n
+}
+
+func isNumber(n *node, v int64) bool {
+	return n.item.Type == TokenFloatNumber && n.item.Number.Equal(decimal.NewFromInt(v))
+}
+
+func Derivative(exp, name string) (string, error) {
+	return Default().Derivative(exp, name)
+}
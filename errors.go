@@ -1 +1,38 @@
 package decexpr
+
+import (
+	"fmt"
+
+	pkgErrors "github.com/pkg/errors"
+)
+
+// Ошибки превышения ограничений Limits; проверяются через errors.Is.
+var (
+	ErrMaxDepth     = pkgErrors.New("maximum nesting depth exceeded")
+	ErrMaxRPNLength = pkgErrors.New("maximum expression size exceeded")
+	ErrMaxSteps     = pkgErrors.New("maximum evaluation steps exceeded")
+	ErrMaxDigits    = pkgErrors.New("maximum number of digits exceeded")
+	ErrMaxExponent  = pkgErrors.New("maximum exponent exceeded")
+)
+
+// LimitError - превышение одного из ограничений Limits. Limit - одна из ошибок ErrMax*.
+type LimitError struct {
+	Limit    error
+	Max      int
+	Position int
+	Line     int
+	Column   int
+}
+
+func newLimitError(limit error, max int, at *Token) *LimitError {
+	return &LimitError{Limit: limit, Max: max, Position: at.Position, Line: at.Line, Column: at.Column}
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s: limit %d, token position:%d, line:%d, column:%d",
+		e.Limit, e.Max, e.Position, e.Line, e.Column)
+}
+
+func (e *LimitError) Unwrap() error {
+	return e.Limit
+}
@@ -1,8 +1,12 @@
 package decexpr
 
 import (
+	"context"
+	"maps"
+	"slices"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	pkgErrors "github.com/pkg/errors"
 	"github.com/shopspring/decimal"
@@ -11,7 +15,8 @@ import (
 var defaultEval atomic.Pointer[ExpressionEvaluator]
 
 func init() {
-	defaultEval.Store(NewExpressionEvaluator(true, functions))
+	// Default получает копию: функции, добавленные через AddFunc, не попадают во встроенные
+	defaultEval.Store(NewExpressionEvaluator(true, BuiltinFunctions()))
 }
 
 func Default() *ExpressionEvaluator { return defaultEval.Load() }
@@ -20,78 +25,339 @@ func SetDefault(eval *ExpressionEvaluator) {
 	defaultEval.Store(eval)
 }
 
+// BuiltinFunctions возвращает копию встроенных функций для NewExpressionEvaluator: вычислитель с настройками
+// получает те же функции, что и Default, а функции, добавленные в него, не попадают в другие вычислители.
+func BuiltinFunctions() map[string]FuncInfo {
+	return maps.Clone(functions)
+}
+
 type ExpressionEvaluator struct {
-	functions map[string]FuncInfo
-	parser    *Parser
-	cache     EvalCache
-	lock      sync.RWMutex
+	functions       map[string]FuncInfo
+	parser          *Parser
+	cache           *programCache
+	clock           func() time.Time
+	nullPropagation bool
+	maxCallDepth    int
+	parserOpts      []ParserOption
+	limits          Limits
+	lock            sync.RWMutex
+}
+
+// DefaultMaxCallDepth - максимальная глубина вложенных вызовов пользовательских функций.
+const DefaultMaxCallDepth = 256
+
+type EvaluatorOption func(e *ExpressionEvaluator)
+
+// WithClock задает источник текущего времени для функций today() и now().
+func WithClock(clock func() time.Time) EvaluatorOption {
+	return func(e *ExpressionEvaluator) {
+		e.clock = clock
+	}
+}
+
+// WithNullPropagation включает SQL-семантику null: операторы и функции с null-аргументом возвращают null.
+// Без этой опции null допускается только в ??, ==, != и функциях coalesce, isnull, ifnull.
+func WithNullPropagation() EvaluatorOption {
+	return func(e *ExpressionEvaluator) {
+		e.nullPropagation = true
+	}
+}
+
+// WithMaxCallDepth ограничивает глубину вложенных вызовов пользовательских функций (рекурсии).
+func WithMaxCallDepth(depth int) EvaluatorOption {
+	return func(e *ExpressionEvaluator) {
+		e.maxCallDepth = depth
+	}
+}
+
+// WithPercentLiterals включает проценты: "price * 15%" = price * 0.15, "rate%" = rate / 100.
+// В этом режиме '%' всегда означает процент, остаток от деления вычисляет функция mod().
+func WithPercentLiterals() EvaluatorOption {
+	return func(e *ExpressionEvaluator) {
+		e.parserOpts = append(e.parserOpts, WithLexerOptions(WithLexerPercent()))
+	}
+}
+
+// WithMathSyntax включает неявное умножение ("2x + 3(y - 1)") и операторы "×", "÷", "**".
+func WithMathSyntax() EvaluatorOption {
+	return func(e *ExpressionEvaluator) {
+		e.parserOpts = append(e.parserOpts, WithImplicitMultiplication(), WithLexerOptions(WithLexerMathOperators()))
+	}
 }
 
-func NewExpressionEvaluator(useCache bool, functions map[string]FuncInfo) *ExpressionEvaluator {
+// WithLocale задает разделители чисел и аргументов, например EuropeanLocale для "sum(1 234,5; 10)".
+func WithLocale(locale Locale) EvaluatorOption {
+	return func(e *ExpressionEvaluator) {
+		e.parserOpts = append(e.parserOpts, WithLexerOptions(WithLexerLocale(locale)))
+	}
+}
+
+// WithFunctionAliases добавляет локализованные имена функций, например {"сумма": "sum", "если": "if"}.
+func WithFunctionAliases(aliases map[string]string) EvaluatorOption {
+	return func(e *ExpressionEvaluator) {
+		e.parserOpts = append(e.parserOpts, WithParserAliases(aliases))
+	}
+}
+
+// WithMaxLength ограничивает длину выражения в байтах (по умолчанию DefaultMaxLength); 0 - без ограничения.
+func WithMaxLength(n int) EvaluatorOption {
+	return func(e *ExpressionEvaluator) {
+		e.parserOpts = append(e.parserOpts, WithLexerOptions(WithLexerMaxLength(n)))
+	}
+}
+
+// WithLimits ограничивает ресурсы разбора и вычисления, см. Limits и DefaultLimits.
+// Превышение ограничения возвращает *LimitError.
+func WithLimits(limits Limits) EvaluatorOption {
+	return func(e *ExpressionEvaluator) {
+		e.limits = limits
+		e.parserOpts = append(e.parserOpts, WithParserLimits(limits))
+	}
+}
+
+// MissingPolicy определяет поведение при отсутствии значения идентификатора.
+type MissingPolicy byte
+
+const (
+	MissingError MissingPolicy = iota
+	MissingNull
+	MissingDefault
+)
+
+// evalState - настройки и состояние одного вызова Eval.
+type evalState struct {
+	missing         MissingPolicy
+	missingDefault  Value
+	nullPropagation bool
+
+	// globals - идентификаторы, переданные в Eval: в них вычисляются тела пользовательских функций
+	globals   identResolver
+	callDepth int
+	maxDepth  int
+	limits    Limits
+	steps     int
+
+	// ctx - контекст EvalContext; done равен nil, если контекст не может быть отменен
+	ctx  context.Context
+	done <-chan struct{}
+
+	// lets - значения переменных let сценария, если их нужно вернуть вызывающему
+	lets map[string]Value
+
+	// nums - буфер аргументов числовых функций, numBuf - его начальная память
+	nums   []decimal.Decimal
+	numBuf [8]decimal.Decimal
+
+	// slots - значения переменных EvalSlots
+	slots slotIdents
+
+	// env - настройки замыканий, созданных вычислением
+	env *closureEnv
+}
+
+// EvalOption - настройка отдельного вызова Eval.
+type EvalOption func(o *evalState)
+
+func WithMissing(policy MissingPolicy) EvalOption {
+	return func(o *evalState) {
+		o.missing = policy
+	}
+}
+
+// WithMissingDefault подставляет значение по умолчанию вместо отсутствующих идентификаторов.
+func WithMissingDefault(value Value) EvalOption {
+	return func(o *evalState) {
+		o.missing = MissingDefault
+		o.missingDefault = value
+	}
+}
+
+func NewExpressionEvaluator(useCache bool, functions map[string]FuncInfo, opts ...EvaluatorOption) *ExpressionEvaluator {
 	funcArgs := make(map[string]int)
 	for k, v := range functions {
 		funcArgs[k] = v.Args
 	}
 
-	var evalCache EvalCache
-
-	if useCache {
-		evalCache = NewEvalMapCache()
-	} else {
-		evalCache = NewEvalNoopCache()
+	e := &ExpressionEvaluator{
+		functions:    functions,
+		cache:        newProgramCache(useCache),
+		clock:        time.Now,
+		maxCallDepth: DefaultMaxCallDepth,
 	}
 
-	return &ExpressionEvaluator{
-		functions: functions,
-		parser:    NewParser(funcArgs),
-		cache:     evalCache,
+	for _, opt := range opts {
+		opt(e)
 	}
+
+	e.parser = NewParser(funcArgs, e.parserOpts...)
+
+	return e
 }
 
 func (e *ExpressionEvaluator) ClearCache() {
 	e.lock.RLock()
 	defer e.lock.RUnlock()
 
-	e.cache.Clear()
+	e.cache.clear()
 }
 
 func (e *ExpressionEvaluator) ParseAndCache(exp string) error {
-	e.lock.RLock()
-	defer e.lock.RUnlock()
+	_, err := e.Compile(exp)
 
-	_, ok := e.cache.Get(exp)
-	if !ok {
-		items, err := e.parser.Parse(exp)
-		if err != nil {
-			return err
-		}
+	return err
+}
 
-		e.cache.Put(exp, items)
+func (e *ExpressionEvaluator) Eval(exp string, identValue map[string]decimal.Decimal, opts ...EvalOption) (decimal.Decimal, error) {
+	res, err := e.eval(exp, decimalIdents(identValue), opts)
+	if err != nil {
+		return decimal.Decimal{}, err
 	}
 
-	return nil
+	if !res.IsNumber() {
+		return decimal.Decimal{}, pkgErrors.Errorf("invalid expression: %s: result is %s, expected number", exp, res.Kind())
+	}
+
+	return res.Number(), nil
+}
+
+// EvalContext вычисляет выражение с контекстом: отмена и дедлайн проверяются между шагами вычисления,
+// контекст передается в функции, добавленные через AddContextFunc.
+func (e *ExpressionEvaluator) EvalContext(ctx context.Context, exp string, identValue map[string]decimal.Decimal, opts ...EvalOption) (decimal.Decimal, error) {
+	res, err := e.eval(exp, decimalIdents(identValue), append(slices.Clip(opts), withContext(ctx)))
+	if err != nil {
+		return decimal.Decimal{}, err
+	}
+
+	if !res.IsNumber() {
+		return decimal.Decimal{}, pkgErrors.Errorf("invalid expression: %s: result is %s, expected number", exp, res.Kind())
+	}
+
+	return res.Number(), nil
+}
+
+// EvalValueContext - EvalValue с контекстом, см. EvalContext.
+func (e *ExpressionEvaluator) EvalValueContext(ctx context.Context, exp string, identValue map[string]Value, opts ...EvalOption) (Value, error) {
+	return e.eval(exp, valueIdents(identValue), append(slices.Clip(opts), withContext(ctx)))
+}
+
+// withContext задает контекст вычисления; nil равносилен context.Background().
+func withContext(ctx context.Context) EvalOption {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	return func(o *evalState) {
+		o.ctx = ctx
+		o.done = ctx.Done()
+	}
+}
+
+func (e *ExpressionEvaluator) EvalValue(exp string, identValue map[string]Value, opts ...EvalOption) (Value, error) {
+	return e.eval(exp, valueIdents(identValue), opts)
 }
 
-func (e *ExpressionEvaluator) Eval(exp string, identValue map[string]decimal.Decimal) (res decimal.Decimal, err error) {
+func (e *ExpressionEvaluator) EvalBool(exp string, identValue map[string]Value, opts ...EvalOption) (bool, error) {
+	res, err := e.eval(exp, valueIdents(identValue), opts)
+	if err != nil {
+		return false, err
+	}
+
+	if !res.IsBool() {
+		return false, pkgErrors.Errorf("invalid expression: %s: result is %s, expected bool", exp, res.Kind())
+	}
+
+	return res.Bool(), nil
+}
+
+// EvalScript вычисляет сценарий "let base = price * qty; let tax = base * rate; base + tax".
+// Возвращает значение последнего выражения и значения всех переменных let.
+func (e *ExpressionEvaluator) EvalScript(script string, identValue map[string]Value, opts ...EvalOption) (Value, map[string]Value, error) {
+	lets := make(map[string]Value)
+
+	res, err := e.eval(script, valueIdents(identValue), append(slices.Clip(opts), func(o *evalState) {
+		o.lets = lets
+	}))
+	if err != nil {
+		return Value{}, nil, err
+	}
+
+	return res, lets, nil
+}
+
+func (e *ExpressionEvaluator) eval(exp string, idents identResolver, opts []EvalOption) (Value, error) {
+	p, err := e.Compile(exp)
+	if err != nil {
+		return Value{}, err
+	}
+
+	return p.run(idents, opts)
+}
+
+// Compile разбирает выражение и компилирует его в байт-код. Программу можно вычислять
+// многократно и из нескольких горутин; с включенным кэшем программа берется из кэша.
+func (e *ExpressionEvaluator) Compile(exp string) (*Program, error) {
 	e.lock.RLock()
 	defer e.lock.RUnlock()
 
-	items, ok := e.cache.Get(exp)
-	if !ok {
-		items, err = e.parser.Parse(exp)
-		if err != nil {
-			return decimal.Decimal{}, err
-		}
+	p, ok := e.cache.get(exp)
+	if ok {
+		return p, nil
+	}
 
-		e.cache.Put(exp, items)
+	items, err := e.parser.Parse(exp)
+	if err != nil {
+		return nil, err
 	}
 
-	res, err = e.evalRPN(items, identValue)
+	p, err = e.compile(exp, items)
 	if err != nil {
-		return decimal.Decimal{}, pkgErrors.Wrapf(err, "invalid expression: %s", exp)
+		return nil, pkgErrors.Wrapf(err, "invalid expression: %s", exp)
+	}
+
+	e.cache.put(exp, p)
+
+	return p, nil
+}
+
+// statePool - состояния вычислений, переиспользуемые между вызовами.
+var statePool = sync.Pool{
+	New: func() any {
+		return new(evalState)
+	},
+}
+
+// newState создает состояние одного вычисления с настройками вычислителя и вызова.
+// После вычисления состояние возвращается в пул через releaseState.
+func (e *ExpressionEvaluator) newState(idents identResolver, opts []EvalOption) *evalState {
+	st := statePool.Get().(*evalState)
+	e.initState(st, idents, opts)
+
+	return st
+}
+
+// releaseState возвращает состояние в пул; замыкания, пережившие вычисление, сохраняют копию его настроек.
+func releaseState(st *evalState) {
+	if st.env != nil {
+		st.env.detach(st)
 	}
 
-	return res, nil
+	*st = evalState{}
+	statePool.Put(st)
+}
+
+// initState заполняет обнуленное состояние из пула; поля задаются по одному,
+// чтобы не копировать всю структуру поверх нулевой.
+func (e *ExpressionEvaluator) initState(st *evalState, idents identResolver, opts []EvalOption) {
+	st.ctx = context.Background()
+	st.nullPropagation = e.nullPropagation
+	st.globals = idents
+	st.maxDepth = e.maxCallDepth
+	st.limits = e.limits
+	st.nums = st.numBuf[:0]
+
+	for _, opt := range opts {
+		opt(st)
+	}
 }
 
 func (e *ExpressionEvaluator) AddFunc(name string, funcCall Function) error {
@@ -107,129 +373,87 @@ func (e *ExpressionEvaluator) AddFunc(name string, funcCall Function) error {
 		Call: funcCall,
 		Args: -1,
 	}
+	e.parser.SetFunction(name, -1)
+
+	return nil
+}
+
+// AddContextFunc добавляет функцию с переменным числом аргументов, получающую контекст EvalContext.
+// При вызове через Eval функция получает context.Background().
+func (e *ExpressionEvaluator) AddContextFunc(name string, funcCall ContextFunction) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	_, ok := e.functions[name]
+	if ok {
+		return pkgErrors.Errorf("function %s already registered", name)
+	}
+
+	e.functions[name] = FuncInfo{
+		ContextCall: funcCall,
+		Args:        -1,
+	}
+	e.parser.SetFunction(name, -1)
 
 	return nil
 }
 
-func (e *ExpressionEvaluator) evalRPN(items []*RPNItem, identValue map[string]decimal.Decimal) (decimal.Decimal, error) {
-	stack := NewNumberStack(len(items))
-
-	for len(items) > 0 {
-		item := items[0]
-		switch item.Type {
-		case TokenFloatNumber:
-			stack.Push(item.Number)
-		case TokenIdent:
-			value, ok := identValue[item.Literal]
-			if !ok {
-				return decimal.Decimal{}, pkgErrors.Errorf(
-					"ident value not found for %s, token position:%d",
-					item.Literal, item.Position)
-			}
-
-			stack.Push(value)
-		case TokenUnaryOperator:
-			if stack.Len() < 1 {
-				return decimal.Decimal{}, pkgErrors.Errorf(
-					"invalid unary operator '%s', token position:%d",
-					item.Literal, item.Position)
-			}
-
-			switch item.Literal {
-			case "-":
-				value := stack.Pop()
-				stack.Push(value.Neg())
-			default:
-				return decimal.Decimal{}, pkgErrors.Errorf(
-					"unsupported unary operator '%s', token position:%d",
-					item.Literal, item.Position)
-			}
-		case TokenOperator:
-			if stack.Len() < 2 {
-				return decimal.Decimal{}, pkgErrors.Errorf(
-					"invalid operator %s, token position:%d",
-					item.Literal, item.Position)
-			}
-
-			var (
-				val  decimal.Decimal
-				val2 = stack.Pop()
-				val1 = stack.Pop()
-			)
-
-			switch item.Literal {
-			case "+":
-				val = val1.Add(val2)
-			case "-":
-				val = val1.Sub(val2)
-			case "*":
-				val = val1.Mul(val2)
-			case "/":
-				if val2.IsZero() {
-					return decimal.Decimal{}, pkgErrors.Errorf("division by 0, token position:%d", item.Position)
-				}
-
-				val = val1.Div(val2)
-			case "%":
-				if val2.IsZero() {
-					return decimal.Decimal{}, pkgErrors.Errorf("division by 0, token position:%d", item.Position)
-				}
-
-				val = val1.Mod(val2)
-			case "^":
-				val = val1.Pow(val2)
-			default:
-				return decimal.Decimal{}, pkgErrors.Errorf("unsupported operator '%s', token position:%d",
-					item.Literal, item.Position)
-			}
-
-			stack.Push(val)
-		case TokenFunction:
-			function, ok := e.functions[item.Literal]
-			if !ok {
-				return decimal.Decimal{}, pkgErrors.Errorf("unknown function '%s', token position:%d",
-					item.Literal, item.Position)
-			}
-
-			if stack.Len() < item.FuncArgCount {
-				return decimal.Decimal{}, pkgErrors.Errorf("invalid function %s, token position:%d",
-					item.Literal, item.Position,
-				)
-			}
-
-			vals, err := stack.PopN(item.FuncArgCount)
-			if err != nil {
-				return decimal.Decimal{}, err
-			}
-
-			v, err := function.Call(vals...)
-			if err != nil {
-				return decimal.Decimal{}, pkgErrors.Wrapf(err, "invalid function '%s', token position:%d",
-					item.Literal, item.Position)
-			}
-
-			stack.Push(v)
-		default:
-			return decimal.Decimal{}, pkgErrors.Errorf("unknown token '%s', token position:%d",
-				item.Literal, item.Position)
-		}
-
-		items = items[1:]
-	}
-
-	val := stack.Pop()
-
-	if stack.Len() > 0 {
-		return decimal.Decimal{}, pkgErrors.New("stack values is not empty")
-	}
-
-	return val, nil
-}
-
-func Eval(exp string, identValue map[string]decimal.Decimal) (decimal.Decimal, error) {
-	return Default().Eval(exp, identValue)
+func (e *ExpressionEvaluator) callFunction(function *FuncInfo, vals []Value, st *evalState) (Value, error) {
+	if st.nullPropagation && !function.NullAware && slices.ContainsFunc(vals, Value.IsNull) {
+		return NullValue(), nil
+	}
+
+	if function.clockCall != nil {
+		return function.clockCall(e.clock(), vals...)
+	}
+
+	if function.user != nil {
+		return e.callUserFunction(function.user, vals, st)
+	}
+
+	if function.ContextCall != nil {
+		return function.ContextCall(st.ctx, vals...)
+	}
+
+	return function.call(vals, &st.nums)
+}
+
+func Eval(exp string, identValue map[string]decimal.Decimal, opts ...EvalOption) (decimal.Decimal, error) {
+	return Default().Eval(exp, identValue, opts...)
+}
+
+func EvalContext(ctx context.Context, exp string, identValue map[string]decimal.Decimal, opts ...EvalOption) (decimal.Decimal, error) {
+	return Default().EvalContext(ctx, exp, identValue, opts...)
+}
+
+func EvalValueContext(ctx context.Context, exp string, identValue map[string]Value, opts ...EvalOption) (Value, error) {
+	return Default().EvalValueContext(ctx, exp, identValue, opts...)
+}
+
+func EvalValue(exp string, identValue map[string]Value, opts ...EvalOption) (Value, error) {
+	return Default().EvalValue(exp, identValue, opts...)
+}
+
+func EvalBool(exp string, identValue map[string]Value, opts ...EvalOption) (bool, error) {
+	return Default().EvalBool(exp, identValue, opts...)
+}
+
+func EvalScript(script string, identValue map[string]Value, opts ...EvalOption) (Value, map[string]Value, error) {
+	return Default().EvalScript(script, identValue, opts...)
 }
 
 func AddFunc(name string, funcCall Function) error {
 	return Default().AddFunc(name, funcCall)
 }
+
+func AddContextFunc(name string, funcCall ContextFunction) error {
+	return Default().AddContextFunc(name, funcCall)
+}
+
+func DefineFunc(name string, params []string, body string) error {
+	return Default().DefineFunc(name, params, body)
+}
+
+func Define(def string) error {
+	return Default().Define(def)
+}
@@ -0,0 +1,115 @@
+package decexpr
+
+import (
+	"context"
+	"runtime"
+	"sync"
+
+	pkgErrors "github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+)
+
+// EvalAllOptions - настройки EvalAll.
+type EvalAllOptions struct {
+	// Concurrency - число одновременно вычисляемых формул, по умолчанию GOMAXPROCS.
+	Concurrency int
+	// FailFast останавливает вычисление на первой ошибке: возвращается только она,
+	// формулы, которые не успели вычислиться, отсутствуют в результатах. Если же вычисление
+	// остановлено отменой ctx, ошибка контекста сообщается для каждой невычисленной формулы.
+	FailFast bool
+	// Eval - настройки каждого вызова Eval, например WithMissing.
+	Eval []EvalOption
+}
+
+// EvalAll вычисляет набор именованных формул с одними и теми же переменными пулом горутин.
+// Формулы разбираются один раз и берутся из кэша вычислителя. Результаты и ошибки возвращаются по именам формул.
+// nil ctx равносилен context.Background().
+func (e *ExpressionEvaluator) EvalAll(ctx context.Context, formulas map[string]string, vars map[string]decimal.Decimal,
+	opts EvalAllOptions,
+) (map[string]decimal.Decimal, map[string]error) {
+	workers := opts.Concurrency
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	parent := ctx
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	names := make(chan string)
+	results := make(map[string]decimal.Decimal, len(formulas))
+	errs := make(map[string]error)
+
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+
+	for range min(workers, len(formulas)) {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for name := range names {
+				res, err := e.EvalContext(ctx, formulas[name], vars, opts.Eval...)
+
+				mu.Lock()
+
+				switch {
+				case err == nil:
+					results[name] = res
+				case !opts.FailFast:
+					errs[name] = err
+				case len(errs) == 0:
+					// остальные формулы останавливаются отменой контекста, их ошибки не сообщаются
+					errs[name] = err
+					cancel()
+				}
+
+				mu.Unlock()
+			}
+		}()
+	}
+
+loop:
+	for name := range formulas {
+		if !opts.FailFast {
+			// без FailFast отмена вызывающим сообщается ошибкой каждой оставшейся формулы
+			names <- name
+
+			continue
+		}
+
+		select {
+		case names <- name:
+		case <-ctx.Done():
+			break loop
+		}
+	}
+
+	close(names)
+	wg.Wait()
+
+	// отмену вызывающим нельзя спутать с пустым набором формул
+	if opts.FailFast && parent.Err() != nil {
+		for name := range formulas {
+			if _, ok := results[name]; !ok && errs[name] == nil {
+				errs[name] = pkgErrors.Wrapf(parent.Err(), "invalid expression: %s: evaluation stopped", formulas[name])
+			}
+		}
+	}
+
+	return results, errs
+}
+
+func EvalAll(ctx context.Context, formulas map[string]string, vars map[string]decimal.Decimal,
+	opts EvalAllOptions,
+) (map[string]decimal.Decimal, map[string]error) {
+	return Default().EvalAll(ctx, formulas, vars, opts)
+}
@@ -6,6 +6,8 @@ type cacheItem struct {
 	Items []*RPNItem
 }
 
+// EvalCache - кэш разобранных выражений. Вычислитель хранит скомпилированные программы в своем кэше,
+// EvalCache и его реализации сохранены для кода, который кэширует результат Parser.Parse.
 type EvalCache interface {
 	Put(key string, items []*RPNItem)
 	Get(key string) (items []*RPNItem, found bool)
@@ -68,3 +70,48 @@ func (*EvalNoopCache) Get(key string) (items []*RPNItem, found bool) {
 }
 
 func (*EvalNoopCache) Clear() {}
+
+// programCache - кэш программ вычислителя; с nil programs кэш выключен.
+type programCache struct {
+	programs map[string]*Program
+	mutex    sync.RWMutex
+}
+
+func newProgramCache(enabled bool) *programCache {
+	if !enabled {
+		return &programCache{}
+	}
+
+	return &programCache{programs: make(map[string]*Program)}
+}
+
+func (pc *programCache) put(key string, p *Program) {
+	if pc.programs == nil {
+		return
+	}
+
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+
+	pc.programs[key] = p
+}
+
+func (pc *programCache) get(key string) (*Program, bool) {
+	if pc.programs == nil {
+		return nil, false
+	}
+
+	pc.mutex.RLock()
+	defer pc.mutex.RUnlock()
+
+	p, found := pc.programs[key]
+
+	return p, found
+}
+
+func (pc *programCache) clear() {
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+
+	clear(pc.programs)
+}
@@ -0,0 +1,123 @@
+package decexpr
+
+import (
+	"slices"
+
+	pkgErrors "github.com/pkg/errors"
+)
+
+// userFunc - функция, определенная на языке выражений через DefineFunc или Define.
+type userFunc struct {
+	params []string
+	body   *Program
+}
+
+// DefineFunc определяет функцию на языке выражений: DefineFunc("net", []string{"x"}, "x * (1 - discount)").
+// Тело вычисляется с параметрами поверх идентификаторов, переданных в Eval; рекурсия ограничена WithMaxCallDepth.
+func (e *ExpressionEvaluator) DefineFunc(name string, params []string, body string) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if !isIdentName(name) {
+		return pkgErrors.Errorf("invalid function name %q", name)
+	}
+
+	if _, ok := e.functions[name]; ok {
+		return pkgErrors.Errorf("function %s already registered", name)
+	}
+
+	for i, param := range params {
+		if !isIdentName(param) {
+			return pkgErrors.Errorf("invalid parameter name %q of function %s", param, name)
+		}
+
+		if slices.Contains(params[:i], param) {
+			return pkgErrors.Errorf("duplicate parameter %s of function %s", param, name)
+		}
+	}
+
+	fn := &userFunc{params: slices.Clone(params)}
+
+	// функция регистрируется до разбора и компиляции тела, чтобы тело могло вызывать само себя
+	e.parser.SetFunction(name, len(params))
+	e.functions[name] = FuncInfo{
+		Args:      len(params),
+		NullAware: true,
+		user:      fn,
+	}
+
+	items, err := e.parser.Parse(body)
+	if err == nil {
+		fn.body, err = e.compile(body, items)
+	}
+
+	if err != nil {
+		e.parser.RemoveFunction(name)
+		delete(e.functions, name)
+
+		return pkgErrors.Wrapf(err, "invalid function %s body: %s", name, body)
+	}
+
+	return nil
+}
+
+// Define определяет функцию в виде "def net(x) = x * (1 - discount)"; слово def можно опустить.
+func (e *ExpressionEvaluator) Define(def string) error {
+	l, err := NewLexer(def, e.parser.lexerOpts...)
+	if err != nil {
+		return err
+	}
+
+	token := l.NextToken()
+	if token.Type == TokenIdent && token.Literal == "def" {
+		token = l.NextToken()
+	}
+
+	if token.Type != TokenFunction {
+		return pkgErrors.Errorf("invalid definition: %s: expected function name, %s", def, token.location())
+	}
+
+	name := token.Literal
+	params := make([]string, 0, 2)
+
+	if token = l.NextToken(); token.Type != TokenLeftParen {
+		return pkgErrors.Errorf("invalid definition: %s: expected '(', %s", def, token.location())
+	}
+
+	for token = l.NextToken(); token.Type != TokenRightParen; token = l.NextToken() {
+		if len(params) > 0 {
+			if token.Type != TokenComma {
+				return pkgErrors.Errorf("invalid definition: %s: expected ',', %s", def, token.location())
+			}
+
+			token = l.NextToken()
+		}
+
+		if token.Type != TokenIdent {
+			return pkgErrors.Errorf("invalid definition: %s: expected parameter, %s", def, token.location())
+		}
+
+		params = append(params, token.Literal)
+	}
+
+	if token = l.NextToken(); token.Type != TokenAssign {
+		return pkgErrors.Errorf("invalid definition: %s: expected '=', %s", def, token.location())
+	}
+
+	return e.DefineFunc(name, params, def[token.Position+1:])
+}
+
+func (e *ExpressionEvaluator) callUserFunction(fn *userFunc, vals []Value, st *evalState) (Value, error) {
+	if len(vals) != len(fn.params) {
+		return Value{}, pkgErrors.Errorf("function has %d parameters, got %d arguments", len(fn.params), len(vals))
+	}
+
+	if st.maxDepth > 0 && st.callDepth >= st.maxDepth {
+		return Value{}, pkgErrors.Errorf("maximum call depth %d exceeded", st.maxDepth)
+	}
+
+	st.callDepth++
+	defer func() { st.callDepth-- }()
+
+	return e.exec(fn.body, &scopeIdents{names: fn.params, values: vals, parent: st.globals}, st)
+}
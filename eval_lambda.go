@@ -0,0 +1,120 @@
+package decexpr
+
+import (
+	"context"
+	"strings"
+
+	pkgErrors "github.com/pkg/errors"
+)
+
+// closure - лямбда вместе с областью видимости, в которой она была создана.
+type closure struct {
+	eval   *ExpressionEvaluator
+	lambda *compiledLambda
+	idents identResolver
+	env    *closureEnv
+}
+
+// closureEnv - общие для замыканий одного вычисления настройки. Пока вычисление выполняется, замыкания
+// вызываются в его состоянии и расходуют его шаги; после завершения каждый вызов получает свое состояние.
+type closureEnv struct {
+	st *evalState
+
+	missing         MissingPolicy
+	missingDefault  Value
+	nullPropagation bool
+	globals         identResolver
+	maxDepth        int
+	limits          Limits
+	slots           slotIdents
+}
+
+// detach копирует настройки завершившегося вычисления; счетчики, контекст и let не переносятся.
+func (env *closureEnv) detach(st *evalState) {
+	env.st = nil
+	env.missing = st.missing
+	env.missingDefault = st.missingDefault
+	env.nullPropagation = st.nullPropagation
+	env.globals = st.globals
+	env.maxDepth = st.maxDepth
+	env.limits = st.limits
+	env.slots = st.slots
+}
+
+func (c *closure) Call(args ...Value) (Value, error) {
+	return c.CallContext(context.Background(), args...)
+}
+
+// CallContext вызывает замыкание, пережившее вычисление, с контекстом вызывающего; nil равносилен context.Background().
+func (c *closure) CallContext(ctx context.Context, args ...Value) (Value, error) {
+	if len(args) != len(c.lambda.params) {
+		return Value{}, pkgErrors.Errorf("lambda has %d parameters, got %d arguments",
+			len(c.lambda.params), len(args))
+	}
+
+	idents := &scopeIdents{
+		names:  c.lambda.params,
+		values: args,
+		parent: c.idents,
+	}
+
+	// вызов из map, filter и других функций того же вычисления
+	if st := c.env.st; st != nil {
+		return c.eval.exec(c.lambda.body, idents, st)
+	}
+
+	st := statePool.Get().(*evalState)
+	st.missing = c.env.missing
+	st.missingDefault = c.env.missingDefault
+	st.nullPropagation = c.env.nullPropagation
+	st.globals = c.env.globals
+	st.maxDepth = c.env.maxDepth
+	st.limits = c.env.limits
+	st.slots = c.env.slots
+	st.nums = st.numBuf[:0]
+	withContext(ctx)(st)
+
+	res, err := c.eval.exec(c.lambda.body, idents, st)
+	releaseState(st)
+
+	return res, err
+}
+
+// scopeIdents - область видимости параметров лямбды поверх внешних идентификаторов.
+type scopeIdents struct {
+	names  []string
+	values []Value
+	parent identResolver
+}
+
+func (s *scopeIdents) lookup(name string) (Value, bool) {
+	for i, param := range s.names {
+		if param == name {
+			return s.values[i], true
+		}
+	}
+
+	return s.parent.lookup(name)
+}
+
+// lookupIdent ищет идентификатор; имя с точкой, не найденное целиком, разрешается как доступ к полям записи.
+func lookupIdent(idents identResolver, name string) (Value, bool) {
+	if value, ok := idents.lookup(name); ok {
+		return value, true
+	}
+
+	base, path, found := strings.Cut(name, ".")
+	if !found {
+		return Value{}, false
+	}
+
+	value, ok := idents.lookup(base)
+	for ok && path != "" {
+		var field string
+
+		field, path, _ = strings.Cut(path, ".")
+		value, ok = value.Field(field)
+	}
+
+	return value, ok
+}
@@ -0,0 +1,339 @@
+package decexpr
+
+import (
+	"strings"
+	"time"
+
+	pkgErrors "github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+)
+
+var secondsPerDay = decimal.NewFromInt(86400)
+
+func evalUnaryOperator(item *RPNItem, val Value, st *evalState) (Value, error) {
+	if val.IsNull() && st.nullPropagation {
+		return NullValue(), nil
+	}
+
+	switch item.Literal {
+	case OpSub:
+		switch {
+		case val.IsNumber():
+			return NumberValue(val.Number().Neg()), nil
+		case val.IsDuration():
+			return DurationSecondsValue(val.Seconds().Neg()), nil
+		default:
+			return Value{}, unaryTypeError(item, val)
+		}
+	case OpNot:
+		if !val.IsBool() {
+			return Value{}, unaryTypeError(item, val)
+		}
+
+		return BoolValue(!val.Bool()), nil
+	default:
+		return Value{}, pkgErrors.Errorf(
+			"unsupported unary operator '%s', token %s",
+			item.Literal, item.location())
+	}
+}
+
+func evalPostfixOperator(item *RPNItem, val Value, st *evalState) (Value, error) {
+	if val.IsNull() && st.nullPropagation {
+		return NullValue(), nil
+	}
+
+	switch item.Literal {
+	case OpFactorial:
+		if !val.IsNumber() {
+			return Value{}, unaryTypeError(item, val)
+		}
+
+		if err := st.limits.checkFactorial(val.Number(), &item.Token); err != nil {
+			return Value{}, err
+		}
+
+		res, err := Factorial(val.Number())
+		if err != nil {
+			return Value{}, pkgErrors.Wrapf(err, "invalid factorial, token %s", item.location())
+		}
+
+		return NumberValue(res), nil
+	case OpPercent:
+		if !val.IsNumber() {
+			return Value{}, unaryTypeError(item, val)
+		}
+
+		return NumberValue(val.Number().Shift(-2)), nil
+	default:
+		return Value{}, pkgErrors.Errorf(
+			"unsupported postfix operator '%s', token %s",
+			item.Literal, item.location())
+	}
+}
+
+func evalOperator(item *RPNItem, val1, val2 Value, st *evalState) (Value, error) {
+	if item.Literal == OpCoalesce {
+		if val1.IsNull() {
+			return val2, nil
+		}
+
+		return val1, nil
+	}
+
+	if val1.IsNull() || val2.IsNull() {
+		return evalNullOperator(item, val1, val2, st)
+	}
+
+	switch item.Literal {
+	case OpEq:
+		if val1.Kind() != val2.Kind() {
+			return Value{}, binaryTypeError(item, val1, val2)
+		}
+
+		return BoolValue(val1.Equal(val2)), nil
+	case OpNe:
+		if val1.Kind() != val2.Kind() {
+			return Value{}, binaryTypeError(item, val1, val2)
+		}
+
+		return BoolValue(!val1.Equal(val2)), nil
+	case OpAnd, OpOr:
+		if !val1.IsBool() || !val2.IsBool() {
+			return Value{}, binaryTypeError(item, val1, val2)
+		}
+
+		if item.Literal == OpAnd {
+			return BoolValue(val1.Bool() && val2.Bool()), nil
+		}
+
+		return BoolValue(val1.Bool() || val2.Bool()), nil
+	}
+
+	if val1.IsTime() || val2.IsTime() || val1.IsDuration() || val2.IsDuration() {
+		return evalTemporalOperator(item, val1, val2)
+	}
+
+	if !val1.IsNumber() || !val2.IsNumber() {
+		return Value{}, binaryTypeError(item, val1, val2)
+	}
+
+	num1, num2 := val1.Number(), val2.Number()
+
+	switch item.Literal {
+	case OpAdd:
+		return NumberValue(num1.Add(num2)), nil
+	case OpSub:
+		return NumberValue(num1.Sub(num2)), nil
+	case OpMul:
+		return NumberValue(num1.Mul(num2)), nil
+	case OpDiv:
+		if num2.IsZero() {
+			return Value{}, pkgErrors.Errorf("division by 0, token %s", item.location())
+		}
+
+		return NumberValue(num1.Div(num2)), nil
+	case OpMod:
+		if num2.IsZero() {
+			return Value{}, pkgErrors.Errorf("division by 0, token %s", item.location())
+		}
+
+		return NumberValue(num1.Mod(num2)), nil
+	case OpPower:
+		if err := st.limits.checkPower(num1, num2, &item.Token); err != nil {
+			return Value{}, err
+		}
+
+		return NumberValue(num1.Pow(num2)), nil
+	case OpLt, OpLe, OpGt, OpGe:
+		return compareResult(item.Literal, num1.Cmp(num2)), nil
+	default:
+		return Value{}, pkgErrors.Errorf("unsupported operator '%s', token %s",
+			item.Literal, item.location())
+	}
+}
+
+// evalIndex возвращает элемент списка по индексу, отсчитываемому с нуля.
+func evalIndex(item *RPNItem, list, index Value, st *evalState) (Value, error) {
+	if (list.IsNull() || index.IsNull()) && st.nullPropagation {
+		return NullValue(), nil
+	}
+
+	if !list.IsList() || !index.IsNumber() {
+		return Value{}, pkgErrors.Errorf("index is not defined for %s and %s, token %s",
+			list.Kind(), index.Kind(), item.location())
+	}
+
+	i := index.Number()
+	if !i.IsInteger() || i.IsNegative() || i.GreaterThanOrEqual(decimal.NewFromInt(int64(len(list.List())))) {
+		return Value{}, pkgErrors.Errorf("index %s out of range [0, %d), token %s",
+			i.String(), len(list.List()), item.location())
+	}
+
+	return list.List()[i.IntPart()], nil
+}
+
+func evalField(item *RPNItem, record Value, st *evalState) (Value, error) {
+	if record.IsNull() && st.nullPropagation {
+		return NullValue(), nil
+	}
+
+	value := record
+
+	// лексер читает цепочку ".a.b" одним токеном
+	for path := item.Literal; path != ""; {
+		var field string
+
+		field, path, _ = strings.Cut(path, ".")
+
+		if !value.IsRecord() {
+			return Value{}, pkgErrors.Errorf("field '%s' is not defined for %s, token %s",
+				field, value.Kind(), item.location())
+		}
+
+		next, ok := value.Field(field)
+		if !ok {
+			return Value{}, pkgErrors.Errorf("field '%s' not found, token %s", field, item.location())
+		}
+
+		value = next
+	}
+
+	return value, nil
+}
+
+// evalNullOperator вычисляет оператор с null-операндом. При распространении null
+// действует трехзначная логика SQL, иначе null можно только сравнивать через == и !=.
+func evalNullOperator(item *RPNItem, val1, val2 Value, st *evalState) (Value, error) {
+	if !st.nullPropagation {
+		switch item.Literal {
+		case OpEq:
+			return BoolValue(val1.IsNull() && val2.IsNull()), nil
+		case OpNe:
+			return BoolValue(val1.IsNull() != val2.IsNull()), nil
+		default:
+			return Value{}, binaryTypeError(item, val1, val2)
+		}
+	}
+
+	other := val1
+	if val1.IsNull() {
+		other = val2
+	}
+
+	switch item.Literal {
+	case OpAnd:
+		if other.IsBool() && !other.Bool() {
+			return BoolValue(false), nil
+		}
+	case OpOr:
+		if other.IsBool() && other.Bool() {
+			return BoolValue(true), nil
+		}
+	}
+
+	return NullValue(), nil
+}
+
+// evalTemporalOperator реализует арифметику дат и длительностей:
+// дата ± длительность, разность дат, сложение длительностей и умножение длительности на число.
+func evalTemporalOperator(item *RPNItem, val1, val2 Value) (Value, error) {
+	op := item.Literal
+
+	switch {
+	case val1.IsTime() && val2.IsTime():
+		switch op {
+		case OpSub:
+			return DurationSecondsValue(secondsBetween(val2.Time(), val1.Time())), nil
+		case OpLt, OpLe, OpGt, OpGe:
+			return compareResult(op, val1.Time().Compare(val2.Time())), nil
+		}
+	case val1.IsTime() && val2.IsDuration():
+		switch op {
+		case OpAdd:
+			return addSeconds(item, val1, val2.Seconds())
+		case OpSub:
+			return addSeconds(item, val1, val2.Seconds().Neg())
+		}
+	case val1.IsDuration() && val2.IsTime():
+		if op == OpAdd {
+			return addSeconds(item, val2, val1.Seconds())
+		}
+	case val1.IsDuration() && val2.IsDuration():
+		switch op {
+		case OpAdd:
+			return DurationSecondsValue(val1.Seconds().Add(val2.Seconds())), nil
+		case OpSub:
+			return DurationSecondsValue(val1.Seconds().Sub(val2.Seconds())), nil
+		case OpDiv:
+			if val2.Seconds().IsZero() {
+				return Value{}, pkgErrors.Errorf("division by 0, token %s", item.location())
+			}
+
+			return NumberValue(val1.Seconds().Div(val2.Seconds())), nil
+		case OpLt, OpLe, OpGt, OpGe:
+			return compareResult(op, val1.Seconds().Cmp(val2.Seconds())), nil
+		}
+	case val1.IsDuration() && val2.IsNumber():
+		switch op {
+		case OpMul:
+			return DurationSecondsValue(val1.Seconds().Mul(val2.Number())), nil
+		case OpDiv:
+			if val2.Number().IsZero() {
+				return Value{}, pkgErrors.Errorf("division by 0, token %s", item.location())
+			}
+
+			return DurationSecondsValue(val1.Seconds().Div(val2.Number())), nil
+		}
+	case val1.IsNumber() && val2.IsDuration():
+		if op == OpMul {
+			return DurationSecondsValue(val2.Seconds().Mul(val1.Number())), nil
+		}
+	}
+
+	return Value{}, binaryTypeError(item, val1, val2)
+}
+
+func compareResult(op Operator, cmp int) Value {
+	switch op {
+	case OpLt:
+		return BoolValue(cmp < 0)
+	case OpLe:
+		return BoolValue(cmp <= 0)
+	case OpGt:
+		return BoolValue(cmp > 0)
+	default:
+		return BoolValue(cmp >= 0)
+	}
+}
+
+// secondsBetween возвращает точное количество секунд t2 - t1.
+func secondsBetween(t1, t2 time.Time) decimal.Decimal {
+	return decimal.New(t2.Unix()-t1.Unix(), 0).Add(decimal.New(int64(t2.Nanosecond()-t1.Nanosecond()), -9))
+}
+
+// addSeconds сдвигает дату на длительность; дата остается датой, если сдвиг кратен суткам.
+func addSeconds(item *RPNItem, val Value, seconds decimal.Decimal) (Value, error) {
+	d, err := toDuration(seconds)
+	if err != nil {
+		return Value{}, pkgErrors.Wrapf(err, "invalid operator '%s', token %s", item.Literal, item.location())
+	}
+
+	t := val.Time().Add(d)
+
+	if val.Kind() == KindDate && seconds.Mod(secondsPerDay).IsZero() {
+		return DateValue(t), nil
+	}
+
+	return DateTimeValue(t), nil
+}
+
+func unaryTypeError(item *RPNItem, val Value) error {
+	return pkgErrors.Errorf("operator '%s' is not defined for %s, token %s",
+		item.Literal, val.Kind(), item.location())
+}
+
+func binaryTypeError(item *RPNItem, val1, val2 Value) error {
+	return pkgErrors.Errorf("operator '%s' is not defined for %s and %s, token %s",
+		item.Literal, val1.Kind(), val2.Kind(), item.location())
+}
@@ -1,8 +1,14 @@
 package decexpr
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"maps"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/shopspring/decimal"
 	"github.com/stretchr/testify/assert"
@@ -31,6 +37,26 @@ func TestEval(t *testing.T) {
 		{exp: "(5+5)*5", idents: map[string]decimal.Decimal{}, result: "50"},
 		{exp: "round(5.3555, 2)", idents: map[string]decimal.Decimal{}, result: "5.36"},
 		{exp: "trunc(5.3555, 2)", idents: map[string]decimal.Decimal{}, result: "5.35"},
+		{exp: "div(7, 2)", idents: map[string]decimal.Decimal{}, result: "3"},
+		{exp: "div(-7, 2)", idents: map[string]decimal.Decimal{}, result: "-3"},
+		{exp: "div(7.5, 2) * 2 + 7.5 % 2", idents: map[string]decimal.Decimal{}, result: "7.5"},
+		{exp: "gcd(12, 18, -30)", idents: map[string]decimal.Decimal{}, result: "6"},
+		{exp: "lcm(4, 6, 10)", idents: map[string]decimal.Decimal{}, result: "60"},
+		{exp: "lcm(4, 0)", idents: map[string]decimal.Decimal{}, result: "0"},
+		{exp: "5!", idents: map[string]decimal.Decimal{}, result: "120"},
+		{exp: "0!", idents: map[string]decimal.Decimal{}, result: "1"},
+		{exp: "2^3!", idents: map[string]decimal.Decimal{}, result: "64"},
+		{exp: "-3! + (1+2)!", idents: map[string]decimal.Decimal{}, result: "0"},
+		{exp: "max(2, 3)!", idents: map[string]decimal.Decimal{}, result: "6"},
+		{exp: "25!", idents: map[string]decimal.Decimal{}, result: "15511210043330985984000000"},
+		{exp: "sign(-2.5) + sign(0) + sign(7)", idents: map[string]decimal.Decimal{}, result: "0"},
+		{exp: "clamp(15, 0, 10)", idents: map[string]decimal.Decimal{}, result: "10"},
+		{exp: "clamp(-1.5, 0, 10)", idents: map[string]decimal.Decimal{}, result: "0"},
+		{exp: "mod(7.5, 2)", idents: map[string]decimal.Decimal{}, result: "1.5"},
+		{exp: "pct_change(80, 100)", idents: map[string]decimal.Decimal{}, result: "0.25"},
+		{exp: "markup(50, 0.2)", idents: map[string]decimal.Decimal{}, result: "60"},
+		{exp: "ln(1) + exp(0)", idents: map[string]decimal.Decimal{}, result: "1"},
+		{exp: "round(exp(ln(5)), 10)", idents: map[string]decimal.Decimal{}, result: "5"},
 	}
 	for _, test := range tests {
 		t.Run(test.exp, func(t *testing.T) {
@@ -41,6 +67,1421 @@ func TestEval(t *testing.T) {
 	}
 }
 
+func TestEvalBool(t *testing.T) {
+	tests := []struct {
+		exp    string
+		idents map[string]Value
+		result bool
+	}{
+		{exp: "true", result: true},
+		{exp: "!false", result: true},
+		{exp: "1 + 2 == 3", result: true},
+		{exp: "1 + 2 != 3", result: false},
+		{exp: "2 * 3 > 5 && 2 < 1 || 1 <= 1", result: true},
+		{exp: "!(qty >= 10) || flag == false", idents: map[string]Value{
+			"qty":  NumberValue(decimal.NewFromInt(10)),
+			"flag": BoolValue(false),
+		}, result: true},
+		{exp: "3! >= 6 && !flag", idents: map[string]Value{"flag": BoolValue(true)}, result: false},
+		{exp: "between(qty, 1, 10)", idents: map[string]Value{"qty": NumberValue(decimal.NewFromInt(10))}, result: true},
+		{exp: "between(qty, 1, 10)", idents: map[string]Value{"qty": NumberValue(decimal.NewFromInt(11))}, result: false},
+	}
+	for _, test := range tests {
+		t.Run(test.exp, func(t *testing.T) {
+			v, err := EvalBool(test.exp, test.idents)
+			assert.NoError(t, err)
+			assert.Equal(t, test.result, v)
+		})
+	}
+}
+
+func TestEvalValue_Strings(t *testing.T) {
+	idents := map[string]Value{
+		"category": StringValue("FOOD-01"),
+		"name":     StringValue("Молоко"),
+		"qty":      NumberValue(decimal.NewFromInt(3)),
+	}
+
+	tests := []struct {
+		exp    string
+		result Value
+	}{
+		{exp: `"abc"`, result: StringValue("abc")},
+		{exp: `'it\'s "ok"\n'`, result: StringValue("it's \"ok\"\n")},
+		{exp: `"\u0416"`, result: StringValue("Ж")},
+		{exp: `category == "FOOD-01"`, result: BoolValue(true)},
+		{exp: `category != 'FOOD-01'`, result: BoolValue(false)},
+		{exp: `len(name)`, result: NumberValue(decimal.NewFromInt(6))},
+		{exp: `upper(name)`, result: StringValue("МОЛОКО")},
+		{exp: `lower("AbC")`, result: StringValue("abc")},
+		{exp: `concat(category, " x", qty, " ", true)`, result: StringValue("FOOD-01 x3 true")},
+		{exp: `contains(category, "OD") && startswith(category, "FOOD") && !endswith(category, "2")`, result: BoolValue(true)},
+		{exp: `substr(name, 2)`, result: StringValue("локо")},
+		{exp: `substr(name, 0, 3)`, result: StringValue("Мол")},
+		{exp: `substr(name, 4, 10)`, result: StringValue("ко")},
+		{exp: `tonumber(" 12.50 ") * qty`, result: NumberValue(decimal.RequireFromString("37.5"))},
+	}
+	for _, test := range tests {
+		t.Run(test.exp, func(t *testing.T) {
+			v, err := EvalValue(test.exp, idents)
+			assert.NoError(t, err)
+			assert.True(t, test.result.Equal(v), "expected %s, got %s", test.result, v)
+		})
+	}
+}
+
+func TestEvalValue_Dates(t *testing.T) {
+	clock := func() time.Time { return time.Date(2026, 3, 15, 10, 30, 0, 0, time.UTC) }
+	eval := NewExpressionEvaluator(false, functions, WithClock(clock))
+
+	idents := map[string]Value{
+		"start":    DateValue(time.Date(2026, 1, 31, 0, 0, 0, 0, time.UTC)),
+		"deadline": DateTimeValue(time.Date(2026, 3, 14, 18, 0, 0, 0, time.UTC)),
+		"rate":     NumberValue(decimal.RequireFromString("12.5")),
+	}
+
+	tests := []struct {
+		exp    string
+		result string
+	}{
+		{exp: "today()", result: "2026-03-15"},
+		{exp: "now()", result: "2026-03-15T10:30:00Z"},
+		{exp: "date(2026, 1, 31) == start", result: "true"},
+		{exp: `date("2026-02-28") + days(1)`, result: "2026-03-01"},
+		{exp: "start + hours(36)", result: "2026-02-01T12:00:00Z"},
+		{exp: "start - days(31)", result: "2025-12-31"},
+		{exp: "days_between(start, today())", result: "43"},
+		{exp: "days_between(today(), start)", result: "-43"},
+		{exp: "add_months(start, 1)", result: "2026-02-28"},
+		{exp: "add_months(start, 13)", result: "2027-02-28"},
+		{exp: "add_months(date(2024, 2, 29), 12)", result: "2025-02-28"},
+		{exp: "add_months(date(2026, 2, 28), 1)", result: "2026-03-28"},
+		{exp: "add_months(date(2026, 2, 28), 1, true)", result: "2026-03-31"},
+		{exp: "add_months(date(2026, 3, 31), -1)", result: "2026-02-28"},
+		{exp: "total_hours(now() - deadline) * rate", result: "206.25"},
+		{exp: "now() > deadline", result: "true"},
+		{exp: "total_days(days(2) * 1.5 + hours(12))", result: "3.5"},
+		{exp: "hours(3) / minutes(45)", result: "4"},
+		{exp: "-days(1) < seconds(0)", result: "true"},
+		{exp: `yearfrac(date(2026, 1, 1), date(2026, 7, 1), "30/360")`, result: "0.5"},
+		{exp: `yearfrac(date(2026, 1, 31), date(2026, 2, 28), 0)`, result: "0.0777777777777778"},
+		{exp: `yearfrac(date(2026, 1, 31), date(2026, 3, 31), "30E/360")`, result: "0.1666666666666667"},
+		{exp: `yearfrac(date(2026, 1, 1), date(2026, 3, 2), "ACT/365")`, result: "0.1643835616438356"},
+		{exp: `yearfrac(date(2026, 1, 1), date(2026, 1, 1) + days(73), "ACT/365")`, result: "0.2"},
+		{exp: `yearfrac(date(2027, 7, 1), date(2028, 7, 1), "ACT/ACT")`, result: "1.0013773486039374"},
+		{exp: `datetime(2026, 1, 31, 23, 59, 59)`, result: "2026-01-31T23:59:59Z"},
+		{exp: `datetime("2026-01-31 08:00:00") + minutes(90)`, result: "2026-01-31T09:30:00Z"},
+		{exp: "days(200000)", result: "17280000000s"},
+	}
+	for _, test := range tests {
+		t.Run(test.exp, func(t *testing.T) {
+			v, err := eval.EvalValue(test.exp, idents)
+			assert.NoError(t, err)
+			assert.Equal(t, test.result, v.String())
+		})
+	}
+
+	// длительность больше ~292 лет не помещается в time.Duration
+	for _, exp := range []string{"start + days(200000)", "start - days(200000)", "days(200000) + now()"} {
+		_, err := eval.EvalValue(exp, idents)
+		assert.ErrorContains(t, err, "out of range")
+	}
+
+	v, err := eval.EvalValue("days(200000)", idents)
+	assert.NoError(t, err)
+
+	_, err = v.Duration()
+	assert.Error(t, err)
+
+	d, err := DurationValue(-time.Hour).Duration()
+	assert.NoError(t, err)
+	assert.Equal(t, -time.Hour, d)
+}
+
+func TestEvalValue_Nulls(t *testing.T) {
+	idents := map[string]Value{
+		"discount": NullValue(),
+		"price":    NumberValue(decimal.NewFromInt(100)),
+		"flag":     BoolValue(true),
+	}
+
+	strict := NewExpressionEvaluator(false, functions)
+	sql := NewExpressionEvaluator(false, functions, WithNullPropagation())
+
+	tests := []struct {
+		eval   *ExpressionEvaluator
+		exp    string
+		opts   []EvalOption
+		result string
+	}{
+		{eval: strict, exp: "discount ?? 0.1", result: "0.1"},
+		{eval: strict, exp: "price * (1 - (discount ?? 0))", result: "100"},
+		{eval: strict, exp: "coalesce(discount, null, 5, 6)", result: "5"},
+		{eval: strict, exp: "coalesce(discount, null)", result: "null"},
+		{eval: strict, exp: "isnull(discount) && !isnull(price)", result: "true"},
+		{eval: strict, exp: "ifnull(discount, price)", result: "100"},
+		{eval: strict, exp: "discount == null", result: "true"},
+		{eval: strict, exp: "price != null", result: "true"},
+		{eval: strict, exp: "tax ?? 7", opts: []EvalOption{WithMissing(MissingNull)}, result: "7"},
+		{eval: strict, exp: "isnull(tax)", opts: []EvalOption{WithMissing(MissingNull)}, result: "true"},
+		{eval: strict, exp: "price + tax",
+			opts: []EvalOption{WithMissingDefault(NumberValue(decimal.NewFromInt(5)))}, result: "105"},
+		{eval: sql, exp: "price * discount", result: "null"},
+		{eval: sql, exp: "-discount", result: "null"},
+		{eval: sql, exp: "max(price, discount)", result: "null"},
+		{eval: sql, exp: "discount > 1", result: "null"},
+		{eval: sql, exp: "discount > 1 && false", result: "false"},
+		{eval: sql, exp: "discount > 1 || flag", result: "true"},
+		{eval: sql, exp: "discount > 1 || false", result: "null"},
+		{eval: sql, exp: "(price * discount) ?? price", result: "100"},
+		{eval: sql, exp: "price * discount ?? 2", result: "200"},
+		{eval: strict, exp: "price * (1 - discount ?? 0.5)", result: "50"},
+		{eval: strict, exp: "-discount ?? 2", result: "-2"},
+		{eval: strict, exp: "2 ^ discount ?? 3", result: "8"},
+		{eval: sql, exp: "upper(tax)", opts: []EvalOption{WithMissing(MissingNull)}, result: "null"},
+	}
+	for _, test := range tests {
+		t.Run(test.exp, func(t *testing.T) {
+			v, err := test.eval.EvalValue(test.exp, idents, test.opts...)
+			assert.NoError(t, err)
+			assert.Equal(t, test.result, v.String())
+		})
+	}
+
+	for _, exp := range []string{"price * discount", "-discount", "abs(discount)", "discount > 1", "tax + 1"} {
+		t.Run("strict "+exp, func(t *testing.T) {
+			_, err := strict.EvalValue(exp, idents)
+			assert.Error(t, err)
+		})
+	}
+
+	_, err := sql.Eval("a * 2", nil, WithMissing(MissingNull))
+	assert.Error(t, err)
+}
+
+func TestEvalValue_Lists(t *testing.T) {
+	idents := map[string]Value{
+		"line_totals": DecimalListValue([]decimal.Decimal{
+			decimal.RequireFromString("10.5"),
+			decimal.RequireFromString("20"),
+			decimal.RequireFromString("4.25"),
+		}),
+		"empty": ListValue(),
+		"i":     NumberValue(decimal.NewFromInt(1)),
+	}
+
+	tests := []struct {
+		exp    string
+		result string
+	}{
+		{exp: "[1, 2, 3]", result: "[1, 2, 3]"},
+		{exp: "[]", result: "[]"},
+		{exp: "[1, [2, 3], \"a\"][1]", result: "[2, 3]"},
+		{exp: "[1, [2, 3]][1][0]", result: "2"},
+		{exp: "line_totals[2]", result: "4.25"},
+		{exp: "line_totals[i + 1] * 2", result: "8.5"},
+		{exp: "-line_totals[0]", result: "-10.5"},
+		{exp: "len(line_totals) + len(empty)", result: "3"},
+		{exp: "sum(line_totals)", result: "34.75"},
+		{exp: "sum(line_totals, 0.25, [1, 2])", result: "38"},
+		{exp: "max(line_totals) - min(line_totals)", result: "15.75"},
+		{exp: "avg([1, 2, 3, 4])", result: "2.5"},
+		{exp: "sum(empty)", result: "0"},
+		{exp: "[sum(1, 2), max(line_totals)][0]", result: "3"},
+		{exp: "[1, 2] == [1, 2]", result: "true"},
+		{exp: "gcd([12, 18])", result: "6"},
+	}
+	for _, test := range tests {
+		t.Run(test.exp, func(t *testing.T) {
+			v, err := EvalValue(test.exp, idents)
+			assert.NoError(t, err)
+			assert.Equal(t, test.result, v.String())
+		})
+	}
+
+	for _, exp := range []string{"line_totals[3]", "line_totals[-1]", "line_totals[0.5]", "i[0]",
+		"line_totals[0, 1]", "line_totals[]", "[1, 2", "(1, 2]", "abs([1])", "sum([1, true])"} {
+		t.Run("error "+exp, func(t *testing.T) {
+			_, err := EvalValue(exp, idents)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestEvalValue_Lambdas(t *testing.T) {
+	item := func(price, qty string) map[string]Value {
+		return map[string]Value{
+			"price": NumberValue(decimal.RequireFromString(price)),
+			"qty":   NumberValue(decimal.RequireFromString(qty)),
+		}
+	}
+
+	items, err := ValueOf([]map[string]Value{item("10.5", "2"), item("3", "0"), item("1.25", "4")})
+	assert.NoError(t, err)
+
+	idents := map[string]Value{
+		"items":    items,
+		"discount": NumberValue(decimal.RequireFromString("0.1")),
+		"order":    RecordValue(map[string]Value{"customer": RecordValue(map[string]Value{"tier": StringValue("gold")})}),
+	}
+
+	tests := []struct {
+		exp    string
+		result string
+	}{
+		{exp: "map(items, x -> x.price * x.qty)", result: "[21, 0, 5]"},
+		{exp: "sum(map(items, x -> x.price * x.qty * (1 - discount)))", result: "23.4"},
+		{exp: "len(filter(items, x -> x.qty > 0))", result: "2"},
+		{exp: "reduce(items, (acc, x) -> acc + x.qty, 0)", result: "6"},
+		{exp: "any(items, x -> x.qty == 0) && !all(items, x -> x.qty > 0)", result: "true"},
+		{exp: "sumif(items, x -> x.qty > 0, x -> x.price)", result: "11.75"},
+		{exp: "sumif([1, 2, 3, 4], x -> x % 2 == 0)", result: "6"},
+		{exp: "countif(items, x -> x.price < 5)", result: "2"},
+		{exp: "map([1, 2], x -> map([10, 20], y -> x * y + discount))", result: "[[10.1, 20.1], [20.1, 40.1]]"},
+		{exp: "map([3, 4], (x) -> x!)", result: "[6, 24]"},
+		{exp: "reduce([], (a, b) -> a + b, 7)", result: "7"},
+		{exp: "order.customer.tier", result: "gold"},
+		{exp: "items[0].price", result: "10.5"},
+		{exp: "[order][0].customer.tier", result: "gold"},
+		{exp: "items[2]", result: "{price: 1.25, qty: 4}"},
+	}
+	for _, test := range tests {
+		t.Run(test.exp, func(t *testing.T) {
+			v, err := EvalValue(test.exp, idents)
+			assert.NoError(t, err)
+			assert.Equal(t, test.result, v.String())
+		})
+	}
+
+	for _, exp := range []string{"map(items, (a, b) -> a)", "filter(items, x -> x.price)", "map(1, x -> x)",
+		"map(items, 1)", "x -> ", "order.customer.name", "1 -> 2", "abs(x -> x)"} {
+		t.Run("error "+exp, func(t *testing.T) {
+			_, err := EvalValue(exp, idents)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestEvalValue_EscapedClosure(t *testing.T) {
+	eval := NewExpressionEvaluator(false, maps.Clone(functions), WithLimits(Limits{MaxSteps: 50}))
+	assert.NoError(t, eval.Define("def adder(x) = y -> x + y + base"))
+
+	// замыкание из тела пользовательской функции переживает вычисление и сохраняет его переменные
+	add, err := eval.EvalValue("adder(10)", map[string]Value{"base": NumberValue(decimal.NewFromInt(100))})
+	assert.NoError(t, err)
+
+	// каждый вызов получает свое состояние: шаги не накапливаются, вызовы из разных горутин не мешают друг другу,
+	// состояние создавшего вычисления возвращается в пул и может достаться другим вычислениям
+	var wg sync.WaitGroup
+
+	for range 4 {
+		wg.Add(2)
+
+		go func() {
+			defer wg.Done()
+
+			for range 100 {
+				_, err := eval.EvalValue("sum(1, 2) * base", map[string]Value{"base": NumberValue(decimal.NewFromInt(7))})
+				assert.NoError(t, err)
+			}
+		}()
+
+		go func() {
+			defer wg.Done()
+
+			for range 100 {
+				v, err := add.Call(NumberValue(decimal.NewFromInt(1)))
+				assert.NoError(t, err)
+				assert.Equal(t, "111", v.String())
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	// отмена контекста создавшего вычисления не влияет на последующие вызовы
+	ctx, cancel := context.WithCancel(context.Background())
+
+	inc, err := eval.EvalValueContext(ctx, "x -> x + 1", nil)
+	assert.NoError(t, err)
+
+	cancel()
+
+	v, err := inc.Call(NumberValue(decimal.NewFromInt(1)))
+	assert.NoError(t, err)
+	assert.Equal(t, "2", v.String())
+
+	_, err = inc.CallContext(ctx, NumberValue(decimal.NewFromInt(1)))
+	assert.ErrorIs(t, err, context.Canceled)
+
+	var noCtx context.Context
+
+	v, err = inc.CallContext(noCtx, NumberValue(decimal.NewFromInt(2)))
+	assert.NoError(t, err)
+	assert.Equal(t, "3", v.String())
+
+	// ограничение шагов действует на каждый вызов
+	loop, err := eval.EvalValue("n -> reduce([1, 2, 3, 4, 5, 6, 7, 8, 9, 10], (a, x) -> a + x * n, 0)", nil)
+	assert.NoError(t, err)
+
+	_, err = loop.Call(NumberValue(decimal.NewFromInt(1)))
+	assert.ErrorIs(t, err, ErrMaxSteps)
+}
+
+func TestEvalValue_UserFunctions(t *testing.T) {
+	eval := NewExpressionEvaluator(true, maps.Clone(functions), WithMaxCallDepth(50))
+
+	assert.NoError(t, eval.DefineFunc("net", []string{"x"}, "x * (1 - discount)"))
+	assert.NoError(t, eval.Define("def gross(x, rate) = x * (1 + rate)"))
+	assert.NoError(t, eval.Define("fact(n) = if(n <= 1, 1, n * fact(n - 1))"))
+	assert.NoError(t, eval.Define("def loop(n) = loop(n + 1)"))
+	assert.NoError(t, eval.Define("def fee() = 2.5"))
+	assert.NoError(t, eval.AddFunc("twice", func(nums ...decimal.Decimal) (decimal.Decimal, error) {
+		return nums[0].Mul(decimal.NewFromInt(2)), nil
+	}))
+
+	idents := map[string]Value{
+		"discount": NumberValue(decimal.RequireFromString("0.1")),
+		"x":        NumberValue(decimal.NewFromInt(1000)),
+	}
+
+	tests := []struct {
+		exp    string
+		result string
+	}{
+		{exp: "net(200)", result: "180"},
+		{exp: "net(x) + fee()", result: "902.5"},
+		{exp: "gross(net(100), 0.2)", result: "108"},
+		{exp: "map([10, 20], p -> net(p))", result: "[9, 18]"},
+		{exp: "fact(10)", result: "3628800"},
+		{exp: "twice(fee())", result: "5"},
+		{exp: "if(x > 0, 1, 1 / 0)", result: "1"},
+		{exp: "if(null, 1, 2)", result: "2"},
+	}
+	for _, test := range tests {
+		t.Run(test.exp, func(t *testing.T) {
+			v, err := eval.EvalValue(test.exp, idents)
+			assert.NoError(t, err)
+			assert.Equal(t, test.result, v.String())
+		})
+	}
+
+	for _, exp := range []string{"net(1, 2)", "fee(1)", "loop(1)", "fact(60)", "if(1, 2, 3)", "if(true, 1)"} {
+		t.Run("error "+exp, func(t *testing.T) {
+			_, err := eval.EvalValue(exp, idents)
+			assert.Error(t, err)
+		})
+	}
+
+	for _, def := range []string{"def net(x) = x", "def f(x, x) = x", "def g(x) = (x", "def h(x) x", "def 1(x) = x", "def k(x.y) = x"} {
+		t.Run("define "+def, func(t *testing.T) {
+			assert.Error(t, eval.Define(def))
+		})
+	}
+
+	_, err := Eval("net(1)", nil)
+	assert.Error(t, err)
+}
+
+func TestEvalScript(t *testing.T) {
+	idents := map[string]Value{
+		"price": NumberValue(decimal.RequireFromString("12.5")),
+		"qty":   NumberValue(decimal.NewFromInt(4)),
+		"rate":  NumberValue(decimal.RequireFromString("0.2")),
+	}
+
+	res, lets, err := EvalScript("let base = price * qty; let tax = base * rate; base + tax", idents)
+	assert.NoError(t, err)
+	assert.Equal(t, "60", res.String())
+	assert.Len(t, lets, 2)
+	assert.Equal(t, "50", lets["base"].String())
+	assert.Equal(t, "10", lets["tax"].String())
+
+	tests := []struct {
+		exp    string
+		result string
+	}{
+		{exp: "let a = 2\nlet b = a *\n  3\na + b", result: "8"},
+		{exp: "let price = 1; price + qty", result: "5"},
+		{exp: "let k = 10; map([1, 2], x -> x * k)", result: "[10, 20]"},
+		{exp: "let f = x -> x + qty; let qty = 100; map([1], f)[0] + qty", result: "105"},
+		{exp: "let a = 1;\n\n;a - 1;", result: "0"},
+		{exp: "max(1,\n 2)\n - 1", result: "1"},
+		{exp: "# premium\nlet a = 2 // base\n/* tax\n rate */ a * 3", result: "6"},
+		{exp: `concat("#", "//") /* not a comment inside strings */`, result: "#//"},
+	}
+	for _, test := range tests {
+		t.Run(test.exp, func(t *testing.T) {
+			v, err := EvalValue(test.exp, idents)
+			assert.NoError(t, err)
+			assert.Equal(t, test.result, v.String())
+		})
+	}
+
+	for _, exp := range []string{"let a = 1", "let a = ; a", "1; 2", "let a = 1, 2; a", "max(1; 2)", "map([1], x -> let y = x; y)"} {
+		t.Run("error "+exp, func(t *testing.T) {
+			_, _, err := EvalScript(exp, idents)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestEval_PercentLiterals(t *testing.T) {
+	eval := NewExpressionEvaluator(false, functions, WithPercentLiterals())
+	idents := map[string]decimal.Decimal{"price": decimal.NewFromInt(200)}
+
+	tests := []struct {
+		exp    string
+		result string
+	}{
+		{exp: "price * 15%", result: "30"},
+		{exp: "12.5% * 8", result: "1"},
+		{exp: "markup(50, 20%)", result: "60"},
+		{exp: "price - price * 10% - 5", result: "175"},
+		{exp: "max(15%, 0.1)", result: "0.15"},
+		{exp: "15%-3", result: "-2.85"},
+		{exp: "price%-3", result: "-1"},
+		{exp: "price %", result: "2"},
+		{exp: "(15)%", result: "0.15"},
+		{exp: "(price + 100)% * 2", result: "6"},
+		{exp: "-5%", result: "-0.05"},
+		{exp: "mod(10, 4)", result: "2"},
+	}
+	for _, test := range tests {
+		t.Run(test.exp, func(t *testing.T) {
+			v, err := eval.Eval(test.exp, idents)
+			assert.NoError(t, err)
+			assert.Equal(t, test.result, v.String())
+		})
+	}
+
+	// в режиме процентов '%' не является остатком от деления
+	for _, exp := range []string{"10 % 3", "10%3", "price % 7", "% 5"} {
+		t.Run(exp, func(t *testing.T) {
+			_, err := eval.Eval(exp, idents)
+			assert.Error(t, err)
+		})
+	}
+
+	_, err := Eval("price * 15%", idents)
+	assert.Error(t, err)
+
+	d, err := eval.Derivative("(price * 2)% + price * 10%", "price")
+	assert.NoError(t, err)
+	assert.Equal(t, "0.12", d)
+}
+
+func TestEval_MathSyntax(t *testing.T) {
+	eval := NewExpressionEvaluator(false, functions, WithMathSyntax())
+	idents := map[string]decimal.Decimal{"x": decimal.NewFromInt(3), "y": decimal.NewFromInt(5), "a": decimal.NewFromInt(1)}
+
+	tests := []struct {
+		exp    string
+		result string
+	}{
+		{exp: "2x + 3(y - 1)", result: "18"},
+		{exp: "2(a+x)", result: "8"},
+		{exp: "(x + 1)(y - 1)", result: "16"},
+		{exp: "2x^2", result: "18"},
+		{exp: "x (y)", result: "15"},
+		{exp: "3!x", result: "18"},
+		{exp: "2max(x, y)", result: "10"},
+		{exp: "6 ÷ 4 × y", result: "7.5"},
+		{exp: "2 ** 3 * x", result: "24"},
+		{exp: "let b = 2x\n3b", result: "18"},
+	}
+	for _, test := range tests {
+		t.Run(test.exp, func(t *testing.T) {
+			v, err := eval.Eval(test.exp, idents)
+			assert.NoError(t, err)
+			assert.Equal(t, test.result, v.String())
+		})
+	}
+
+	for _, exp := range []string{"2x", "2 ** 3", "6 ÷ 4"} {
+		t.Run("default "+exp, func(t *testing.T) {
+			_, err := Eval(exp, idents)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestEval_Locale(t *testing.T) {
+	idents := map[string]decimal.Decimal{"x": decimal.NewFromInt(2)}
+
+	tests := []struct {
+		locale Locale
+		exp    string
+		result string
+	}{
+		{locale: EuropeanLocale, exp: "1 234,56 * x", result: "2469.12"},
+		{locale: EuropeanLocale, exp: "sum(1 234 567,5; 0,5; [1; 2])", result: "1234571"},
+		{locale: EuropeanLocale, exp: "round(0,125; 2)", result: "0.13"},
+		{locale: EuropeanLocale, exp: "let a = 1,5\na * x", result: "3"},
+		{locale: Locale{DecimalSeparator: ',', GroupSeparator: '.', ArgumentSeparator: ';'}, exp: "1.234,56 + 1.000.000", result: "1001234.56"},
+		{locale: Locale{DecimalSeparator: '.', GroupSeparator: ',', ArgumentSeparator: ';'}, exp: "max(1,234.5; 1,000)", result: "1234.5"},
+		{locale: Locale{DecimalSeparator: '.', GroupSeparator: '\'', ArgumentSeparator: ','}, exp: "1'000'000.25 - 0.25", result: "1000000"},
+		{locale: DefaultLocale, exp: "max(1,5) + 0.25", result: "5.25"},
+	}
+	for _, test := range tests {
+		t.Run(test.exp, func(t *testing.T) {
+			v, err := NewExpressionEvaluator(false, functions, WithLocale(test.locale)).Eval(test.exp, idents)
+			assert.NoError(t, err)
+			assert.Equal(t, test.result, v.String())
+		})
+	}
+
+	invalid := []struct {
+		locale Locale
+		exp    string
+	}{
+		{locale: EuropeanLocale, exp: "max(1, 2)"},
+		{locale: EuropeanLocale, exp: "1 2345"},
+		{locale: EuropeanLocale, exp: "max(12 34; 5)"},
+		{locale: Locale{DecimalSeparator: ',', ArgumentSeparator: ','}, exp: "1"},
+		{locale: Locale{DecimalSeparator: '.', GroupSeparator: '_', ArgumentSeparator: ','}, exp: "1"},
+	}
+	for _, test := range invalid {
+		t.Run("error "+test.exp, func(t *testing.T) {
+			_, err := NewExpressionEvaluator(false, functions, WithLocale(test.locale)).Eval(test.exp, idents)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestEval_UnicodeIdents(t *testing.T) {
+	eval := NewExpressionEvaluator(false, maps.Clone(functions),
+		WithFunctionAliases(map[string]string{"сумма": "sum", "если": "if", "макс": "max"}),
+		WithLocale(EuropeanLocale))
+	assert.NoError(t, eval.Define("def нетто(x) = x * (1 - скидка)"))
+
+	idents := map[string]Value{
+		"цена":            NumberValue(decimal.RequireFromString("12.5")),
+		"количество":      NumberValue(decimal.NewFromInt(4)),
+		"скидка":          NumberValue(decimal.RequireFromString("0.1")),
+		"prix_été":        NumberValue(decimal.NewFromInt(3)),
+		"मूल्य":           NumberValue(decimal.NewFromInt(5)),
+		"e\u0301te\u0301": NumberValue(decimal.NewFromInt(2)),
+		"x١":              NumberValue(decimal.NewFromInt(1)),
+		"заказ":           RecordValue(map[string]Value{"сумма": NumberValue(decimal.NewFromInt(7))}),
+	}
+
+	tests := []struct {
+		exp    string
+		result string
+	}{
+		{exp: "цена * количество", result: "50"},
+		{exp: "сумма(цена; количество; 0,5)", result: "17"},
+		{exp: "если(цена > 10; макс(1; 2); 0)", result: "2"},
+		{exp: "нетто(цена * количество)", result: "45"},
+		{exp: "prix_été * 2", result: "6"},
+		{exp: "заказ.сумма + [заказ][0].сумма", result: "14"},
+		{exp: "sum(1; 2)", result: "3"},
+		{exp: "मूल्य * 2", result: "10"},
+		{exp: "e\u0301te\u0301 + x١", result: "3"},
+	}
+	for _, test := range tests {
+		t.Run(test.exp, func(t *testing.T) {
+			v, err := eval.EvalValue(test.exp, idents)
+			assert.NoError(t, err)
+			assert.Equal(t, test.result, v.String())
+		})
+	}
+
+	_, err := EvalValue("сумма(1, 2)", idents)
+	assert.Error(t, err)
+
+	_, err = EvalValue("цена × 2", idents)
+	assert.ErrorContains(t, err, "×")
+
+	// комбинируемый знак не может начинать идентификатор
+	_, err = EvalValue("\u0301e + 1", idents)
+	assert.ErrorContains(t, err, "\u0301")
+}
+
+func TestEval_LongExpression(t *testing.T) {
+	exp := "sum(" + strings.Repeat("1.5, ", 20000) + "0)"
+
+	v, err := Eval(exp, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "30000", v.String())
+
+	_, err = NewExpressionEvaluator(false, functions, WithMaxLength(1000)).Eval(exp, nil)
+	assert.Error(t, err)
+
+	v, err = NewExpressionEvaluator(false, functions, WithMaxLength(0)).Eval(exp, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "30000", v.String())
+
+	_, err = Eval("1 +\n  2 $", nil)
+	assert.ErrorContains(t, err, "line:2, column:5")
+
+	// строка и столбец есть и в ошибках лексера, компиляции и вычисления
+	lex, err := NewLexer("1 +\n  2 $")
+	assert.NoError(t, err)
+
+	_, err = lex.Tokenize()
+	assert.EqualError(t, err, "invalid token type: $, position:8, line:2, column:5")
+
+	_, err = Eval("1 +\n  (2", nil)
+	assert.ErrorContains(t, err, "line:2, column:3")
+
+	_, err = Eval("1 +\n  2 / 0", nil)
+	assert.ErrorContains(t, err, "division by 0, token position:8, line:2, column:5")
+
+	_, err = NewExpressionEvaluator(false, functions, WithLimits(Limits{MaxDigits: 5})).Eval("1 +\n  99999 * 99", nil)
+
+	var limitErr *LimitError
+	assert.ErrorAs(t, err, &limitErr)
+	assert.Equal(t, 2, limitErr.Line)
+	assert.Equal(t, 9, limitErr.Column)
+}
+
+func TestEval_Limits(t *testing.T) {
+	eval := NewExpressionEvaluator(false, maps.Clone(functions), WithLimits(Limits{
+		MaxDepth:     5,
+		MaxRPNLength: 50,
+		MaxSteps:     200,
+		MaxDigits:    30,
+		MaxExponent:  100,
+	}))
+	assert.NoError(t, eval.Define("def fact(n) = if(n <= 1, 1, n * fact(n - 1))"))
+
+	for _, exp := range []string{
+		"2^90", "((((1))))", "25!", "max(1, 2) * 10^20", "2^-20", "fact(10)",
+		"123456789012345678901234567890", "0000000000000000000000000000000000001",
+	} {
+		t.Run(exp, func(t *testing.T) {
+			_, err := eval.Eval(exp, nil)
+			assert.NoError(t, err)
+		})
+	}
+
+	tests := []struct {
+		exp   string
+		limit error
+	}{
+		{exp: "2^999999999", limit: ErrMaxExponent},
+		{exp: "2^-101", limit: ErrMaxExponent},
+		{exp: "10^40", limit: ErrMaxDigits},
+		{exp: "1000000! ", limit: ErrMaxDigits},
+		{exp: "10^25 * 10^25", limit: ErrMaxDigits},
+		{exp: "1.0001^90", limit: ErrMaxDigits},
+		{exp: "0.5^-50", limit: ErrMaxDigits},
+		{exp: "1234567890123456789012345678901", limit: ErrMaxDigits},
+		{exp: "((((((1))))))", limit: ErrMaxDepth},
+		{exp: "[[[[[[1]]]]]]", limit: ErrMaxDepth},
+		{exp: "sum(" + strings.Repeat("1, ", 60) + "1)", limit: ErrMaxRPNLength},
+		{exp: "sum(map([1, 2, 3, 4, 5, 6, 7, 8, 9, 10], x -> reduce([1, 2, 3, 4, 5, 6, 7, 8, 9, 10], (a, y) -> a + y * x, 0)))", limit: ErrMaxSteps},
+		{exp: "fact(60)", limit: ErrMaxSteps},
+	}
+	for _, test := range tests {
+		t.Run(test.exp, func(t *testing.T) {
+			_, err := eval.Eval(test.exp, nil)
+			assert.ErrorIs(t, err, test.limit)
+
+			var limitErr *LimitError
+			assert.True(t, errors.As(err, &limitErr))
+		})
+	}
+
+	v, err := Eval("2^200", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 61, v.NumDigits())
+
+	// литералы длиннее int64 разбираются точно
+	v, err = Eval("12345678901234567890123 + 0.100000000000000000001", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "12345678901234567890123.100000000000000000001", v.String())
+
+	// точная степень с дробным основанием отклоняется до вычисления
+	eval = NewExpressionEvaluator(false, maps.Clone(functions), WithLimits(Limits{MaxDigits: 1000, MaxRPNLength: 5}))
+
+	start := time.Now()
+	_, err = eval.Eval("1.0001^2000000", nil)
+	assert.ErrorIs(t, err, ErrMaxDigits)
+	assert.Less(t, time.Since(start), time.Second)
+
+	_, err = eval.Eval("1 + 2 * 3 +\n 4", nil)
+
+	var limitErr *LimitError
+	assert.True(t, errors.As(err, &limitErr))
+	assert.Equal(t, ErrMaxRPNLength, limitErr.Limit)
+	assert.Equal(t, 10, limitErr.Position)
+}
+
+func TestEvalContext(t *testing.T) {
+	type rateKey struct{}
+
+	eval := NewExpressionEvaluator(false, maps.Clone(functions))
+	assert.NoError(t, eval.AddContextFunc("fx", func(ctx context.Context, vals ...Value) (Value, error) {
+		if err := ctx.Err(); err != nil {
+			return Value{}, err
+		}
+
+		rate, ok := ctx.Value(rateKey{}).(decimal.Decimal)
+		if !ok {
+			return Value{}, errors.New("no rate")
+		}
+
+		return NumberValue(vals[0].Number().Mul(rate)), nil
+	}))
+
+	ctx := context.WithValue(context.Background(), rateKey{}, decimal.RequireFromString("0.5"))
+	idents := map[string]decimal.Decimal{"amount": decimal.NewFromInt(30)}
+
+	v, err := eval.EvalContext(ctx, "fx(amount) + 1", idents)
+	assert.NoError(t, err)
+	assert.Equal(t, "16", v.String())
+
+	_, err = eval.Eval("fx(amount)", idents)
+	assert.Error(t, err)
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+
+	_, err = eval.EvalContext(cancelled, "1 + 2", idents)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	expired, cancelExpired := context.WithDeadline(ctx, time.Now().Add(-time.Second))
+	defer cancelExpired()
+
+	_, err = eval.EvalValueContext(expired, "map([1, 2], x -> x)", nil)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// nil равносилен context.Background()
+	var noCtx context.Context
+
+	v, err = eval.EvalContext(noCtx, "1 + 2", idents)
+	assert.NoError(t, err)
+	assert.Equal(t, "3", v.String())
+}
+
+func TestEvalMapCache(t *testing.T) {
+	items, err := NewParser(map[string]int{"max": -1}).Parse("max(a, 2) * 3")
+	assert.NoError(t, err)
+
+	// кэш разобранных выражений работает как до компиляции в байт-код
+	var cache EvalCache = NewEvalMapCache()
+
+	cache.Put("max(a, 2) * 3", items)
+
+	cached, ok := cache.Get("max(a, 2) * 3")
+	assert.True(t, ok)
+	assert.Equal(t, "a 2 max:2 3 *", sprintItems(cached))
+
+	cache.Clear()
+
+	_, ok = cache.Get("max(a, 2) * 3")
+	assert.False(t, ok)
+}
+
+func TestCompile(t *testing.T) {
+	eval := NewExpressionEvaluator(true, maps.Clone(functions))
+
+	p, err := eval.Compile("-2 * 3 + sum(price, qty) / 2")
+	assert.NoError(t, err)
+	assert.Equal(t, "-2 * 3 + sum(price, qty) / 2", p.Source())
+
+	cached, err := eval.Compile("-2 * 3 + sum(price, qty) / 2")
+	assert.NoError(t, err)
+	assert.Same(t, p, cached)
+
+	var wg sync.WaitGroup
+
+	for i := range 8 {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			v, err := p.Eval(map[string]decimal.Decimal{
+				"price": decimal.NewFromInt(int64(i)),
+				"qty":   decimal.NewFromInt(10),
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, decimal.NewFromInt(int64(i)+10).Div(decimal.NewFromInt(2)).Sub(decimal.NewFromInt(6)).String(), v.String())
+		}()
+	}
+
+	wg.Wait()
+
+	_, err = p.Eval(map[string]decimal.Decimal{"price": decimal.NewFromInt(1)})
+	assert.EqualError(t, err, "invalid expression: -2 * 3 + sum(price, qty) / 2: ident value not found for qty, token position:20, line:1, column:21")
+
+	_, err = p.EvalValue(map[string]Value{"price": NullValue(), "qty": NumberValue(decimal.NewFromInt(1))})
+	assert.Error(t, err)
+
+	for _, exp := range []string{"2 +", "1 2", "-"} {
+		_, err = eval.Compile(exp)
+		assert.Error(t, err, exp)
+	}
+
+	_, err = eval.Compile("1 / 0")
+	assert.NoError(t, err)
+
+	_, err = eval.Eval("1 / 0", nil)
+	assert.EqualError(t, err, "invalid expression: 1 / 0: division by 0, token position:2, line:1, column:3")
+}
+
+func TestProgram_EvalSlots(t *testing.T) {
+	eval := NewExpressionEvaluator(false, maps.Clone(functions))
+
+	p, err := eval.Compile("let net = price * qty; net - net * discount + sum(map([fee], x -> x))")
+	assert.NoError(t, err)
+
+	_, err = p.Bind([]string{"price", "qty"})
+	assert.EqualError(t, err, "variable discount is not bound, token position:35, line:1, column:36")
+
+	_, err = p.Bind([]string{"price", "price"})
+	assert.EqualError(t, err, "duplicate slot price")
+
+	bound, err := p.Bind([]string{"fee", "discount", "price", "qty", "unused"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"fee", "discount", "price", "qty", "unused"}, bound.Slots())
+
+	slots := []decimal.Decimal{
+		decimal.NewFromInt(5),
+		decimal.RequireFromString("0.1"),
+		decimal.NewFromInt(20),
+		decimal.NewFromInt(3),
+		decimal.Zero,
+	}
+
+	v, err := bound.EvalSlots(slots)
+	assert.NoError(t, err)
+	assert.Equal(t, "59", v.String())
+
+	v, err = bound.Eval(map[string]decimal.Decimal{
+		"fee": decimal.NewFromInt(5), "discount": decimal.RequireFromString("0.1"),
+		"price": decimal.NewFromInt(20), "qty": decimal.NewFromInt(3),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "59", v.String())
+
+	_, err = bound.EvalSlots(slots[:2])
+	assert.Error(t, err)
+
+	p, err = eval.Compile("max(a, min(b, c), 0)")
+	assert.NoError(t, err)
+
+	bound, err = p.Bind([]string{"a", "b", "c"})
+	assert.NoError(t, err)
+
+	slots = []decimal.Decimal{decimal.NewFromInt(-1), decimal.NewFromInt(7), decimal.NewFromInt(4)}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		v, err = bound.EvalSlots(slots)
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "4", v.String())
+	assert.Zero(t, allocs)
+}
+
+func TestProgram_EvalBatch(t *testing.T) {
+	eval := NewExpressionEvaluator(false, maps.Clone(functions))
+
+	const rows = 3000
+
+	columns := map[string][]decimal.Decimal{
+		"price": make([]decimal.Decimal, rows),
+		"qty":   make([]decimal.Decimal, rows),
+	}
+
+	for i := range rows {
+		columns["price"][i] = decimal.New(int64(i), -1)
+		columns["qty"][i] = decimal.NewFromInt(int64(i % 7))
+	}
+
+	for _, exp := range []string{
+		"-price * max(qty, 1) + round(price / 3, 2)",
+		"price / qty",
+		"let net = price * qty; net - 1",
+		"if(qty > 3, price, -price)",
+	} {
+		p, err := eval.Compile(exp)
+		assert.NoError(t, err)
+
+		for _, workers := range []int{1, 4} {
+			res, errs := p.EvalBatch(columns, WithBatchWorkers(workers))
+			assert.Len(t, res, rows)
+
+			for i := range rows {
+				v, err := p.Eval(map[string]decimal.Decimal{"price": columns["price"][i], "qty": columns["qty"][i]})
+				if err != nil {
+					assert.EqualError(t, errs[i], err.Error(), "%s row %d", exp, i)
+
+					continue
+				}
+
+				if errs != nil {
+					assert.NoError(t, errs[i], "%s row %d", exp, i)
+				}
+
+				assert.Equal(t, v.String(), res[i].String(), "%s row %d", exp, i)
+			}
+		}
+	}
+
+	p, err := eval.Compile("price * qty")
+	assert.NoError(t, err)
+
+	res, errs := p.EvalBatch(map[string][]decimal.Decimal{"price": columns["price"][:1]})
+	assert.Nil(t, res)
+	assert.Len(t, errs, 1)
+	assert.ErrorContains(t, errs[0], "variable qty is not bound")
+
+	_, errs = p.EvalBatch(map[string][]decimal.Decimal{"price": columns["price"], "qty": columns["qty"][:10]})
+	assert.Len(t, errs, rows)
+	assert.ErrorContains(t, errs[rows-1], "rows, expected")
+
+	// пустой пакет сообщает ошибку привязки одной ошибкой
+	res, errs = p.EvalBatch(nil)
+	assert.Nil(t, res)
+	assert.Len(t, errs, 1)
+	assert.ErrorContains(t, errs[0], "is not bound")
+
+	// блоки и построчное вычисление считают шаги одинаково: ограничение срабатывает на той же инструкции
+	for steps := 1; steps <= 8; steps++ {
+		limited := NewExpressionEvaluator(false, maps.Clone(functions), WithLimits(Limits{MaxSteps: steps}))
+
+		for _, exp := range []string{"price * 2", "price * qty + 1", "max(price, qty) - -qty", "let x = price; x * qty"} {
+			p, err = limited.Compile(exp)
+			assert.NoError(t, err)
+
+			res, errs = p.EvalBatch(map[string][]decimal.Decimal{"price": columns["price"][:5], "qty": columns["qty"][:5]})
+
+			_, err = p.Eval(map[string]decimal.Decimal{"price": columns["price"][4], "qty": columns["qty"][4]})
+			if err != nil {
+				assert.Len(t, errs, 5)
+				assert.EqualError(t, errs[4], err.Error(), "%s, MaxSteps %d", exp, steps)
+
+				continue
+			}
+
+			assert.Nil(t, errs, "%s, MaxSteps %d", exp, steps)
+			assert.Len(t, res, 5)
+		}
+	}
+
+	res, errs = p.EvalBatch(map[string][]decimal.Decimal{"price": {}, "qty": {}})
+	assert.Empty(t, res)
+	assert.Nil(t, errs)
+
+	// тело пользовательской функции читает глобальную переменную строки
+	assert.NoError(t, eval.Define("net(x) = x * (1 - discount)"))
+
+	p, err = eval.Compile("net(qty) + 1")
+	assert.NoError(t, err)
+
+	res, errs = p.EvalBatch(map[string][]decimal.Decimal{
+		"qty":      {decimal.NewFromInt(10), decimal.NewFromInt(20)},
+		"discount": {decimal.RequireFromString("0.1"), decimal.RequireFromString("0.5")},
+	})
+	assert.Nil(t, errs)
+	assert.Equal(t, []string{"10", "11"}, []string{res[0].String(), res[1].String()})
+}
+
+func TestEvalAll(t *testing.T) {
+	eval := NewExpressionEvaluator(true, maps.Clone(functions))
+
+	formulas := make(map[string]string)
+	for i := range 200 {
+		formulas[fmt.Sprintf("f%d", i)] = fmt.Sprintf("price * %d + fee", i)
+	}
+
+	formulas["broken"] = "price / zero"
+	formulas["missing"] = "price * unknown"
+
+	vars := map[string]decimal.Decimal{
+		"price": decimal.NewFromInt(2),
+		"fee":   decimal.NewFromInt(1),
+		"zero":  decimal.Zero,
+	}
+
+	res, errs := eval.EvalAll(context.Background(), formulas, vars, EvalAllOptions{Concurrency: 4})
+	assert.Len(t, res, 200)
+	assert.Equal(t, "21", res["f10"].String())
+	assert.Len(t, errs, 2)
+	assert.EqualError(t, errs["broken"], "invalid expression: price / zero: division by 0, token position:6, line:1, column:7")
+	assert.ErrorContains(t, errs["missing"], "ident value not found for unknown")
+
+	res, errs = eval.EvalAll(context.Background(), formulas, vars, EvalAllOptions{
+		Concurrency: 4,
+		Eval:        []EvalOption{WithMissingDefault(NumberValue(decimal.NewFromInt(3)))},
+	})
+	assert.Equal(t, "6", res["missing"].String())
+	assert.Len(t, errs, 1)
+
+	res, errs = eval.EvalAll(context.Background(), formulas, vars, EvalAllOptions{Concurrency: 2, FailFast: true})
+	assert.Len(t, errs, 1)
+	assert.Less(t, len(res), len(formulas))
+
+	for name := range errs {
+		assert.Contains(t, []string{"broken", "missing"}, name)
+	}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	res, errs = eval.EvalAll(cancelled, formulas, vars, EvalAllOptions{})
+	assert.Empty(t, res)
+	assert.Len(t, errs, len(formulas))
+	assert.ErrorIs(t, errs["f1"], context.Canceled)
+
+	res, errs = eval.EvalAll(cancelled, formulas, vars, EvalAllOptions{FailFast: true})
+	assert.Empty(t, res)
+	assert.Len(t, errs, len(formulas))
+
+	for _, err := range errs {
+		assert.ErrorIs(t, err, context.Canceled)
+	}
+
+	// nil равносилен context.Background()
+	var noCtx context.Context
+
+	for _, failFast := range []bool{false, true} {
+		res, errs = eval.EvalAll(noCtx, map[string]string{"a": "price + fee", "b": "price * 3"}, vars,
+			EvalAllOptions{FailFast: failFast})
+		assert.Empty(t, errs)
+		assert.Equal(t, "3", res["a"].String())
+		assert.Equal(t, "6", res["b"].String())
+	}
+}
+
+func TestProgram_Partial(t *testing.T) {
+	num := decimal.RequireFromString
+	vars := map[string]Value{
+		"price": NumberValue(num("40")),
+		"rate":  NumberValue(num("0.2")),
+		"fee":   NumberValue(num("3")),
+		"zero":  NumberValue(num("0")),
+		"items": ListValue(NumberValue(num("1")), NumberValue(num("2"))),
+		"name":  StringValue(`a "b"`),
+	}
+
+	tests := []struct {
+		exp      string
+		known    []string
+		residual string
+	}{
+		{exp: "price * (1 + rate) - fee", known: []string{"rate"}, residual: "price * 1.2 - fee"},
+		{exp: "price * (1 + rate) - fee", known: []string{"rate", "price"}, residual: "48 - fee"},
+		{exp: "price * (1 + rate) - fee", known: []string{"rate", "price", "fee"}, residual: "45"},
+		{exp: "(price - fee) - (rate - fee) * -fee", known: nil, residual: "price - fee - (rate - fee) * -fee"},
+		{exp: "(price - fee) - (rate - fee) * -fee", known: []string{"fee"}, residual: "price - 3 - (rate - 3) * -3"},
+		{exp: "-(price + fee) ^ 2 + (-fee)!", known: []string{"price"}, residual: "-(40 + fee) ^ 2 + (-fee)!"},
+		{exp: "if(rate > 0.1, price * rate, fee)", known: []string{"rate"}, residual: "price * 0.2"},
+		{exp: "if(rate > 0.1, price * rate, fee)", known: []string{"price"}, residual: "if(rate > 0.1, 40 * rate, fee)"},
+		{exp: "let rate = 0.5; price * rate + fee * rate", known: []string{"rate", "fee"}, residual: "let rate = 0.5; price * rate + 3 * rate"},
+		{exp: "sum(map(items, fee -> fee * rate + fee))", known: []string{"fee", "rate"}, residual: "sum(map(items, fee -> fee * 0.2 + fee))"},
+		{exp: "price + fee / zero", known: []string{"fee", "zero"}, residual: "price + 3 / 0"},
+		{exp: "round(fee / 7, 2) * max(price, fee)", known: []string{"fee"}, residual: "0.43 * max(price, 3)"},
+		{exp: `concat(name, "\n", fee) == "x"`, known: []string{"fee"}, residual: `concat(name, "\n", 3) == "x"`},
+		{exp: "[fee, price][1] + sum([fee, 1])", known: []string{"fee"}, residual: "[3, price][1] + sum([3, 1])"},
+		{exp: "price * 2^70 - fee", known: []string{"fee"}, residual: "price * 1180591620717411303424 - 3"},
+	}
+	for _, test := range tests {
+		t.Run(test.exp+" "+strings.Join(test.known, ","), func(t *testing.T) {
+			p, err := Default().Compile(test.exp)
+			assert.NoError(t, err)
+
+			known := make(map[string]decimal.Decimal)
+			for _, name := range test.known {
+				known[name] = vars[name].Number()
+			}
+
+			residual, err := p.Partial(known)
+			assert.NoError(t, err)
+			assert.Equal(t, test.residual, residual.Source())
+
+			want, wantErr := p.EvalValue(vars)
+			got, gotErr := residual.EvalValue(vars)
+			assert.Equal(t, wantErr != nil, gotErr != nil)
+			assert.Equal(t, want.String(), got.String())
+		})
+	}
+
+	eval := NewExpressionEvaluator(false, maps.Clone(functions), WithLocale(EuropeanLocale))
+
+	p, err := eval.Compile("max(a; rate * 1,5; 0)")
+	assert.NoError(t, err)
+
+	residual, err := p.Partial(map[string]decimal.Decimal{"rate": num("0.5")})
+	assert.NoError(t, err)
+	assert.Equal(t, "max(a; 0,75; 0)", residual.Source())
+
+	// функция, добавленная в Default, не вычисляется при компиляции, хотя ее аргументы - литералы
+	calls := 0
+
+	assert.NoError(t, Default().AddFunc("partialrnd", func(nums ...decimal.Decimal) (decimal.Decimal, error) {
+		calls++
+
+		return nums[0], nil
+	}))
+
+	_, ok := BuiltinFunctions()["partialrnd"]
+	assert.False(t, ok)
+
+	p, err = Default().Compile("price + partialrnd(1)")
+	assert.NoError(t, err)
+
+	residual, err = p.Partial(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "price + partialrnd(1)", residual.Source())
+	assert.Zero(t, calls)
+}
+
+func TestDerivative(t *testing.T) {
+	num := decimal.RequireFromString
+	vars := map[string]decimal.Decimal{
+		"price": num("40"),
+		"rate":  num("0.2"),
+		"years": num("3"),
+		"qty":   num("12"),
+	}
+
+	tests := []struct {
+		exp        string
+		derivative string
+	}{
+		{exp: "price * (1 + rate)", derivative: "price"},
+		{exp: "price * (1 + rate) ^ years", derivative: "price * (years * (1 + rate) ^ (years - 1))"},
+		{exp: "3 * rate ^ 2 - rate / 4 + qty", derivative: "6 * rate - 0.25"},
+		{exp: "price / (1 + rate)", derivative: "-price / (1 + rate) ^ 2"},
+		{exp: "2 ^ rate", derivative: "2 ^ rate * 0.6931471805599453"},
+		{exp: "rate ^ rate", derivative: "rate ^ rate * (ln(rate) + 1)"},
+		{exp: "ln(rate) + exp(2 * rate)", derivative: "1 / rate + 2 * exp(2 * rate)"},
+		{exp: "-rate * price - -rate", derivative: "-price + 1"},
+		{exp: "abs(rate - 1) + round(rate, 2)", derivative: "sign(rate - 1)"},
+		{exp: "if(qty > 10, price * rate, rate * rate)", derivative: "if(qty > 10, price, 2 * rate)"},
+		{exp: "max(rate, 2 * rate, 0.3)", derivative: "if(rate >= max(2 * rate, 0.3), 1, if(2 * rate >= 0.3, 2, 0))"},
+		{exp: "clamp(rate, 0, 1) + avg(rate, price)", derivative: "if(rate < 0, 0, if(rate > 1, 0, 1)) + 0.5"},
+		{exp: "markup(price, rate)", derivative: "price"},
+		{exp: "sum(map([1, 2], rate -> rate * qty)) + qty", derivative: "0"},
+		{exp: "rate * 10^19 + qty", derivative: "10000000000000000000"},
+	}
+	for _, test := range tests {
+		t.Run(test.exp, func(t *testing.T) {
+			res, err := Derivative(test.exp, "rate")
+			assert.NoError(t, err)
+			assert.Equal(t, test.derivative, res)
+
+			// производная сравнивается с центральной разностью
+			h := num("0.000001")
+			at := func(rate decimal.Decimal) decimal.Decimal {
+				idents := maps.Clone(vars)
+				idents["rate"] = rate
+
+				v, err := Eval(test.exp, idents)
+				assert.NoError(t, err)
+
+				return v
+			}
+
+			want := at(vars["rate"].Add(h)).Sub(at(vars["rate"].Sub(h))).Div(h.Mul(decimal.NewFromInt(2)))
+
+			got, err := Eval(res, vars)
+			assert.NoError(t, err)
+			assert.Equal(t, want.Round(4).String(), got.Round(4).String())
+		})
+	}
+
+	errTests := []struct {
+		exp string
+		err string
+	}{
+		{exp: "rate > 0.1", err: "operator > has no derivative, token position:5, line:1, column:6"},
+		{exp: "price * len(tonumber(rate))", err: "function len has no derivative, token position:8, line:1, column:9"},
+		{exp: "map([1], x -> x * rate)[0]", err: "'[' has no derivative, token position:23, line:1, column:24"},
+		{exp: "let r = rate; r * 2", err: "derivative of a script with let is not supported, token position:4, line:1, column:5"},
+	}
+	for _, test := range errTests {
+		t.Run(test.exp, func(t *testing.T) {
+			_, err := Derivative(test.exp, "rate")
+			assert.EqualError(t, err, test.err)
+		})
+	}
+
+	builtins := maps.Clone(functions)
+	delete(builtins, "abs")
+
+	eval := NewExpressionEvaluator(false, builtins)
+	assert.NoError(t, eval.AddFunc("abs", Sign))
+
+	_, err := eval.Derivative("abs(rate)", "rate")
+	assert.EqualError(t, err, "function abs has no derivative, token position:0, line:1, column:1")
+
+	// функция, добавленная в Default, остается вызовом и не вычисляется при упрощении
+	calls := 0
+
+	assert.NoError(t, Default().AddFunc("derivrnd", func(nums ...decimal.Decimal) (decimal.Decimal, error) {
+		calls++
+
+		return nums[0], nil
+	}))
+
+	res, err := Derivative("rate * derivrnd(1) * 2", "rate")
+	assert.NoError(t, err)
+	assert.Equal(t, "2 * derivrnd(1)", res)
+	assert.Zero(t, calls)
+}
+
+func TestEvalBool_TypeErrors(t *testing.T) {
+	tests := []string{
+		"true + 1",
+		"-true",
+		"!1",
+		"1 && true",
+		"true == 1",
+		"true < false",
+		"abs(true)",
+		"1 + 2",
+		`"a" + "b"`,
+		`"a" == 1`,
+		`"a" < "b"`,
+		`len(1)`,
+		`substr("abc", 4)`,
+		`tonumber("1,5")`,
+		`"abc`,
+		`"\x"`,
+	}
+	for _, exp := range tests {
+		t.Run(exp, func(t *testing.T) {
+			_, err := EvalBool(exp, nil)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestBuiltinFunctions(t *testing.T) {
+	builtins := BuiltinFunctions()
+	assert.Contains(t, builtins, "sum")
+
+	eval := NewExpressionEvaluator(false, builtins)
+	assert.NoError(t, eval.AddFunc("twice", func(vals ...decimal.Decimal) (decimal.Decimal, error) {
+		return vals[0].Mul(decimal.NewFromInt(2)), nil
+	}))
+
+	v, err := eval.Eval("twice(sum(1, 2))", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "6", v.String())
+
+	// копия не связана с функциями других вычислителей
+	assert.NotContains(t, BuiltinFunctions(), "twice")
+
+	_, err = Eval("twice(1)", nil)
+	assert.Error(t, err)
+}
+
+func TestAddFunc_KeepsArgs(t *testing.T) {
+	eval := NewExpressionEvaluator(false, BuiltinFunctions())
+
+	// функция вызывающего кода может сохранить срез аргументов
+	var kept [][]decimal.Decimal
+	assert.NoError(t, eval.AddFunc("keep", func(vals ...decimal.Decimal) (decimal.Decimal, error) {
+		kept = append(kept, vals)
+
+		return decimal.Zero, nil
+	}))
+
+	_, err := eval.Eval("keep(1, 2) + sum(7, 8, 9) + keep(3, 4)", nil)
+	assert.NoError(t, err)
+
+	assert.Equal(t, [][]decimal.Decimal{
+		{decimal.NewFromInt(1), decimal.NewFromInt(2)},
+		{decimal.NewFromInt(3), decimal.NewFromInt(4)},
+	}, kept)
+}
+
+func TestSum(t *testing.T) {
+	tests := [][]string{
+		{"13", "10", "100", "50"},
+		{"1.25", "-2.50", "0.75"},
+		{"1.5", "2", "0.25"},
+		{"999999999999999", "1"},
+		{"9007199254740993", "1"},
+		{"9223372036854775807", "1"},
+		{"-0", "0.0"},
+	}
+	for _, test := range tests {
+		t.Run(strings.Join(test, ", "), func(t *testing.T) {
+			vals := make([]decimal.Decimal, len(test))
+			for i, s := range test {
+				vals[i] = decimal.RequireFromString(s)
+			}
+
+			// быстрый путь для int64 дает то же число и тот же показатель, что decimal.Sum
+			expected := decimal.Sum(vals[0], vals[1:]...)
+
+			sum, err := Sum(vals...)
+			assert.NoError(t, err)
+			assert.Equal(t, expected.String(), sum.String())
+			assert.Equal(t, expected.Exponent(), sum.Exponent())
+		})
+	}
+}
+
+func TestNumberOperators(t *testing.T) {
+	nums := []string{
+		"0", "1", "-1", "7", "-3", "0.5", "-0.25", "610", "3.1415926535", "-0.0000000002",
+		"123456789012345", "-999999999999999", "1234567890123456", "9223372036854775807",
+		"1.2345678901234567890", "100000000000000000000000", "1e18", "1e-30", "2.00", "2e16", "-4e16", "5e-17",
+	}
+
+	vals := make([]decimal.Decimal, 0, len(nums)+1)
+	for _, s := range nums {
+		vals = append(vals, decimal.RequireFromString(s))
+	}
+
+	// частное с 16 знаками после точки - частый операнд следующих операций
+	vals = append(vals, decimal.NewFromInt(163).Div(decimal.NewFromInt(111)))
+
+	// быстрые операции дают тот же коэффициент и показатель, что decimal
+	same := func(t *testing.T, expected, actual decimal.Decimal) {
+		assert.Equal(t, expected.Coefficient().String(), actual.Coefficient().String())
+		assert.Equal(t, expected.Exponent(), actual.Exponent())
+	}
+
+	for _, a := range vals {
+		for _, b := range vals {
+			t.Run(a.String()+", "+b.String(), func(t *testing.T) {
+				same(t, a.Add(b), addNumbers(a, b))
+				same(t, a.Sub(b), subNumbers(a, b))
+				same(t, a.Mul(b), mulNumbers(a, b))
+				assert.Equal(t, a.Cmp(b), cmpNumbers(a, b))
+
+				if !b.IsZero() {
+					same(t, a.Div(b), divNumbers(a, b))
+				}
+			})
+		}
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	tests := []string{
+		"div(1, 0)",
+		"gcd(1.5, 3)",
+		"lcm(2, 0.5)",
+		"2.5!",
+		"(-1)!",
+		"999999999!",
+		"clamp(1, 10, 0)",
+		"1 < 2",
+		"a = 1",
+		"1 /* open",
+		"mod(1, 0)",
+		"pct_change(0, 1)",
+	}
+	for _, exp := range tests {
+		t.Run(exp, func(t *testing.T) {
+			_, err := Eval(exp, nil)
+			assert.Error(t, err)
+		})
+	}
+}
+
 func TestDecimal(t *testing.T) {
 	val := decimal.NewFromFloat(3123.5612)
 	fmt.Println(val.Round(1).String())
@@ -49,15 +1490,6 @@ func TestDecimal(t *testing.T) {
 	fmt.Println(true)
 }
 
-// BenchmarkEval-12    	  400081	      2658 ns/op	    3848 B/op	      47 allocs/op
-// BenchmarkEval-12    	  479031	      2458 ns/op	    3848 B/op	      46 allocs/op
-// BenchmarkEval-12    	  374209	      2917 ns/op	    5720 B/op	      32 allocs/op
-// BenchmarkEval-12    	  435616	      2763 ns/op	    5208 B/op	      32 allocs/op
-// BenchmarkEval-12    	  480310	      2467 ns/op	    4744 B/op	      41 allocs/op
-// BenchmarkEval-12    	  413218	      2667 ns/op	    3160 B/op	      55 allocs/op
-// BenchmarkEval-12    	  425035	      2550 ns/op	    3144 B/op	      54 allocs/op
-// BenchmarkEval-12    	  462590	      2398 ns/op	    2280 B/op	      54 allocs/op
-// BenchmarkEval-12    	  555511	      1895 ns/op	    1464 B/op	      35 allocs/op
 func BenchmarkEval(b *testing.B) {
 	b.StopTimer()
 	values := map[string]decimal.Decimal{
@@ -87,6 +1519,50 @@ func BenchmarkEval(b *testing.B) {
 		if err != nil {
 			b.Fatal(err)
 		}
-		v = v
+		_ = v
+	}
+}
+
+func BenchmarkProgram_EvalSlots(b *testing.B) {
+	p, err := Default().Compile("20 * 30.5 + sum(13, val1, val2, val3)/(sum(1, max(val1, 100), min(val2, 100)))")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	bound, err := p.Bind([]string{"val1", "val2", "val3"})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	slots := []decimal.Decimal{decimal.NewFromInt(10), decimal.NewFromInt(100), decimal.NewFromInt(50)}
+
+	b.ReportAllocs()
+
+	for b.Loop() {
+		if _, err := bound.EvalSlots(slots); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkProgram_EvalBatch(b *testing.B) {
+	p, err := Default().Compile("20 * 30.5 + sum(13, val1, val2, val3)/(sum(1, max(val1, 100), min(val2, 100)))")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	columns := map[string][]decimal.Decimal{"val1": nil, "val2": nil, "val3": nil}
+	for name := range columns {
+		for i := range 10000 {
+			columns[name] = append(columns[name], decimal.NewFromInt(int64(i%100+1)))
+		}
+	}
+
+	b.ReportAllocs()
+
+	for b.Loop() {
+		if _, errs := p.EvalBatch(columns); errs != nil {
+			b.Fatal(errs)
+		}
 	}
 }
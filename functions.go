@@ -1,27 +1,150 @@
 package decexpr
 
 import (
+	"context"
+	"math"
+	"math/big"
+	"time"
+
 	"github.com/pkg/errors"
 	"github.com/shopspring/decimal"
 )
 
+// Function - числовая функция. Каждый вызов получает новый срез vals, его можно сохранить.
 type Function func(vals ...decimal.Decimal) (decimal.Decimal, error)
 
+// ValueFunction - функция над типизированными значениями (числа, логические значения, строки).
+// Срез vals указывает на стек вычислителя: сохранять его после вызова нельзя, только копию.
+type ValueFunction func(vals ...Value) (Value, error)
+
+// ContextFunction - функция, получающая контекст вызова EvalContext: для отмены
+// долгих операций и значений уровня запроса.
+type ContextFunction func(ctx context.Context, vals ...Value) (Value, error)
+
+// FuncInfo описывает зарегистрированную функцию: задается один из Call, ValueCall или ContextCall.
+// Args - точное число аргументов, -1 для функций с переменным числом аргументов.
+// NullAware - функция сама обрабатывает null-аргументы и вызывается даже при распространении null.
 type FuncInfo struct {
-	Call Function
-	Args int
+	Call        Function
+	ValueCall   ValueFunction
+	ContextCall ContextFunction
+	Args        int
+	NullAware   bool
+
+	// clockCall используется встроенными функциями, которым нужно текущее время вычислителя.
+	clockCall func(now time.Time, vals ...Value) (Value, error)
+	// user - тело функции, определенной через DefineFunc.
+	user *userFunc
+	// reuseArgs - встроенная функция не сохраняет vals, ей передается буфер вычислителя.
+	reuseArgs bool
+}
+
+// call вызывает функцию. Для числовых функций с переменным числом аргументов
+// аргументы-списки разворачиваются: sum(prices) эквивалентно sum(prices[0], prices[1], ...).
+// Встроенные функции получают числовые аргументы в буфере nums, который переиспользуется между вызовами.
+func (fi *FuncInfo) call(vals []Value, nums *[]decimal.Decimal) (Value, error) {
+	if fi.ValueCall != nil {
+		return fi.ValueCall(vals...)
+	}
+
+	args := (*nums)[:0]
+	if !fi.reuseArgs {
+		args = make([]decimal.Decimal, 0, len(vals))
+	}
+
+	for i := range vals {
+		if vals[i].IsList() && fi.Args < 0 {
+			for j, item := range vals[i].List() {
+				if !item.IsNumber() {
+					return Value{}, errors.Errorf("argument %d element %d is %s, expected number", i+1, j, item.Kind())
+				}
+
+				args = append(args, item.Number())
+			}
+
+			continue
+		}
+
+		num, err := numberArg(vals, i)
+		if err != nil {
+			return Value{}, err
+		}
+
+		args = append(args, num)
+	}
+
+	if fi.reuseArgs {
+		*nums = args[:0]
+	}
+
+	res, err := fi.Call(args...)
+	if err != nil {
+		return Value{}, err
+	}
+
+	return NumberValue(res), nil
 }
 
 var functions = map[string]FuncInfo{
-	"max":   {Call: Max, Args: -1},
-	"min":   {Call: Min, Args: -1},
-	"sum":   {Call: Sum, Args: -1},
-	"avg":   {Call: Avg, Args: -1},
-	"round": {Call: Round, Args: 2},
-	"floor": {Call: Floor, Args: 1},
-	"ceil":  {Call: Ceil, Args: 1},
-	"abs":   {Call: Abs, Args: 1},
-	"trunc": {Call: Trunc, Args: 2},
+	"max":     {Call: Max, Args: -1, reuseArgs: true},
+	"min":     {Call: Min, Args: -1, reuseArgs: true},
+	"sum":     {Call: Sum, Args: -1, reuseArgs: true},
+	"avg":     {Call: Avg, Args: -1, reuseArgs: true},
+	"round":   {Call: Round, Args: 2, reuseArgs: true},
+	"floor":   {Call: Floor, Args: 1, reuseArgs: true},
+	"ceil":    {Call: Ceil, Args: 1, reuseArgs: true},
+	"abs":     {Call: Abs, Args: 1, reuseArgs: true},
+	"trunc":   {Call: Trunc, Args: 2, reuseArgs: true},
+	"div":     {Call: Div, Args: 2, reuseArgs: true},
+	"mod":     {Call: Mod, Args: 2, reuseArgs: true},
+	"gcd":     {Call: Gcd, Args: -1, reuseArgs: true},
+	"lcm":     {Call: Lcm, Args: -1, reuseArgs: true},
+	"sign":    {Call: Sign, Args: 1, reuseArgs: true},
+	"ln":      {Call: Ln, Args: 1, reuseArgs: true},
+	"exp":     {Call: Exp, Args: 1, reuseArgs: true},
+	"clamp":   {Call: Clamp, Args: 3, reuseArgs: true},
+	"between": {ValueCall: Between, Args: 3},
+	"if":      {ValueCall: If, Args: 3, NullAware: true},
+
+	"pct_change": {Call: PctChange, Args: 2, reuseArgs: true},
+	"markup":     {Call: Markup, Args: 2, reuseArgs: true},
+
+	"len":        {ValueCall: Len, Args: 1},
+	"upper":      {ValueCall: Upper, Args: 1},
+	"lower":      {ValueCall: Lower, Args: 1},
+	"concat":     {ValueCall: Concat, Args: -1},
+	"contains":   {ValueCall: Contains, Args: 2},
+	"startswith": {ValueCall: StartsWith, Args: 2},
+	"endswith":   {ValueCall: EndsWith, Args: 2},
+	"substr":     {ValueCall: Substr, Args: -1},
+	"tonumber":   {ValueCall: ToNumber, Args: 1},
+
+	"date":          {ValueCall: Date, Args: -1},
+	"datetime":      {ValueCall: DateTime, Args: -1},
+	"today":         {clockCall: today, Args: 0},
+	"now":           {clockCall: now, Args: 0},
+	"days_between":  {ValueCall: DaysBetween, Args: 2},
+	"add_months":    {ValueCall: AddMonths, Args: -1},
+	"yearfrac":      {ValueCall: YearFrac, Args: -1},
+	"days":          {ValueCall: Days, Args: 1},
+	"hours":         {ValueCall: Hours, Args: 1},
+	"minutes":       {ValueCall: Minutes, Args: 1},
+	"seconds":       {ValueCall: Seconds, Args: 1},
+	"total_days":    {ValueCall: TotalDays, Args: 1},
+	"total_hours":   {ValueCall: TotalHours, Args: 1},
+	"total_seconds": {ValueCall: TotalSeconds, Args: 1},
+
+	"coalesce": {ValueCall: Coalesce, Args: -1, NullAware: true},
+	"isnull":   {ValueCall: IsNull, Args: 1, NullAware: true},
+	"ifnull":   {ValueCall: IfNull, Args: 2, NullAware: true},
+
+	"map":     {ValueCall: Map, Args: 2},
+	"filter":  {ValueCall: Filter, Args: 2},
+	"reduce":  {ValueCall: Reduce, Args: 3},
+	"any":     {ValueCall: Any, Args: 2},
+	"all":     {ValueCall: All, Args: 2},
+	"sumif":   {ValueCall: SumIf, Args: -1},
+	"countif": {ValueCall: CountIf, Args: 2},
 }
 
 func Max(vals ...decimal.Decimal) (decimal.Decimal, error) {
@@ -45,9 +168,37 @@ func Sum(vals ...decimal.Decimal) (decimal.Decimal, error) {
 		return decimal.Zero, nil
 	}
 
+	if sum, ok := sumInt64(vals); ok {
+		return sum, nil
+	}
+
 	return decimal.Sum(vals[0], vals[1:]...), nil
 }
 
+// sumInt64 складывает коэффициенты в int64, если у чисел одинаковый показатель и не больше 15 цифр.
+// decimal.Sum выделяет память на каждое сложение, здесь результат создается один раз.
+func sumInt64(vals []decimal.Decimal) (decimal.Decimal, bool) {
+	exp := vals[0].Exponent()
+
+	var sum int64
+
+	for _, v := range vals {
+		// NumDigits не выделяет память для коэффициентов до 2^53
+		if v.Exponent() != exp || v.NumDigits() > 15 {
+			return decimal.Decimal{}, false
+		}
+
+		c := v.CoefficientInt64()
+		if c > 0 && sum > math.MaxInt64-c || c < 0 && sum < math.MinInt64-c {
+			return decimal.Decimal{}, false
+		}
+
+		sum += c
+	}
+
+	return decimal.New(sum, exp), true
+}
+
 func Round(vals ...decimal.Decimal) (decimal.Decimal, error) {
 	switch len(vals) {
 	case 1:
@@ -106,5 +257,265 @@ func Avg(vals ...decimal.Decimal) (decimal.Decimal, error) {
 		return decimal.Zero, nil
 	}
 
-	return decimal.Avg(vals[0], vals[1:]...), nil
+	sum, _ := Sum(vals...)
+
+	return sum.Div(decimal.NewFromInt(int64(len(vals)))), nil
+}
+
+// Div - целочисленное деление с отбрасыванием дробной части (согласовано с оператором %).
+func Div(vals ...decimal.Decimal) (decimal.Decimal, error) {
+	if len(vals) != 2 {
+		return decimal.Zero, errors.New("invalid number of arguments")
+	}
+
+	if vals[1].IsZero() {
+		return decimal.Zero, errors.New("division by 0")
+	}
+
+	q, _ := vals[0].QuoRem(vals[1], 0)
+
+	return q, nil
+}
+
+// Mod возвращает остаток от деления, как оператор %.
+func Mod(vals ...decimal.Decimal) (decimal.Decimal, error) {
+	if len(vals) != 2 {
+		return decimal.Zero, errors.New("invalid number of arguments")
+	}
+
+	if vals[1].IsZero() {
+		return decimal.Zero, errors.New("division by 0")
+	}
+
+	return vals[0].Mod(vals[1]), nil
+}
+
+// PctChange возвращает относительное изменение от a к b долей: pct_change(80, 100) = 0.25.
+func PctChange(vals ...decimal.Decimal) (decimal.Decimal, error) {
+	if len(vals) != 2 {
+		return decimal.Zero, errors.New("invalid number of arguments")
+	}
+
+	if vals[0].IsZero() {
+		return decimal.Zero, errors.New("division by 0")
+	}
+
+	return vals[1].Sub(vals[0]).Div(vals[0].Abs()), nil
+}
+
+// Markup увеличивает стоимость на долю: markup(cost, 20%) = cost * 1.2.
+func Markup(vals ...decimal.Decimal) (decimal.Decimal, error) {
+	if len(vals) != 2 {
+		return decimal.Zero, errors.New("invalid number of arguments")
+	}
+
+	return vals[0].Mul(decimal.NewFromInt(1).Add(vals[1])), nil
+}
+
+// maxExpArgument ограничивает аргумент exp: ряд Тейлора для больших аргументов вычисляется долго.
+const maxExpArgument = 1000
+
+// Ln - натуральный логарифм с точностью деления decimal.DivisionPrecision.
+func Ln(vals ...decimal.Decimal) (decimal.Decimal, error) {
+	if len(vals) != 1 {
+		return decimal.Zero, errors.New("invalid number of arguments")
+	}
+
+	if !vals[0].IsPositive() {
+		return decimal.Zero, errors.New("logarithm of a non-positive number")
+	}
+
+	return vals[0].Ln(int32(decimal.DivisionPrecision))
+}
+
+// Exp - экспонента с точностью деления decimal.DivisionPrecision.
+func Exp(vals ...decimal.Decimal) (decimal.Decimal, error) {
+	if len(vals) != 1 {
+		return decimal.Zero, errors.New("invalid number of arguments")
+	}
+
+	if vals[0].Abs().GreaterThan(decimal.NewFromInt(maxExpArgument)) {
+		return decimal.Zero, errors.Errorf("exp argument is out of range [-%d, %d]", maxExpArgument, maxExpArgument)
+	}
+
+	return vals[0].ExpTaylor(int32(decimal.DivisionPrecision))
+}
+
+func Gcd(vals ...decimal.Decimal) (decimal.Decimal, error) {
+	if len(vals) == 0 {
+		return decimal.Zero, errors.New("invalid number of arguments")
+	}
+
+	res := new(big.Int)
+	for _, val := range vals {
+		if !val.IsInteger() {
+			return decimal.Zero, errors.Errorf("argument %s is not an integer", val.String())
+		}
+
+		res.GCD(nil, nil, res, new(big.Int).Abs(val.BigInt()))
+	}
+
+	return decimal.NewFromBigInt(res, 0), nil
+}
+
+func Lcm(vals ...decimal.Decimal) (decimal.Decimal, error) {
+	if len(vals) == 0 {
+		return decimal.Zero, errors.New("invalid number of arguments")
+	}
+
+	res := big.NewInt(1)
+	for _, val := range vals {
+		if !val.IsInteger() {
+			return decimal.Zero, errors.Errorf("argument %s is not an integer", val.String())
+		}
+
+		v := new(big.Int).Abs(val.BigInt())
+		if v.Sign() == 0 {
+			return decimal.Zero, nil
+		}
+
+		gcd := new(big.Int).GCD(nil, nil, res, v)
+		res.Mul(res.Quo(res, gcd), v)
+	}
+
+	return decimal.NewFromBigInt(res, 0), nil
+}
+
+// MaxFactorial - наибольший аргумент факториала: 10000! содержит 35660 цифр. Ограничение действует
+// и без Limits, чтобы выражение вроде 999999999! не вычислялось бесконечно.
+const MaxFactorial = 10000
+
+func Factorial(val decimal.Decimal) (decimal.Decimal, error) {
+	if !val.IsInteger() || val.IsNegative() {
+		return decimal.Zero, errors.Errorf("argument %s is not a non-negative integer", val.String())
+	}
+
+	if val.GreaterThan(decimal.NewFromInt(MaxFactorial)) {
+		return decimal.Zero, errors.Errorf("argument %s is too large, maximum is %d", val.String(), MaxFactorial)
+	}
+
+	return decimal.NewFromBigInt(new(big.Int).MulRange(1, val.IntPart()), 0), nil
+}
+
+func Sign(vals ...decimal.Decimal) (decimal.Decimal, error) {
+	if len(vals) != 1 {
+		return decimal.Zero, errors.New("invalid number of arguments")
+	}
+
+	return decimal.NewFromInt(int64(vals[0].Sign())), nil
+}
+
+func Clamp(vals ...decimal.Decimal) (decimal.Decimal, error) {
+	if len(vals) != 3 {
+		return decimal.Zero, errors.New("invalid number of arguments")
+	}
+
+	if vals[1].GreaterThan(vals[2]) {
+		return decimal.Zero, errors.Errorf("lower bound %s is greater than upper bound %s",
+			vals[1].String(), vals[2].String())
+	}
+
+	return decimal.Min(decimal.Max(vals[0], vals[1]), vals[2]), nil
+}
+
+func Between(vals ...Value) (Value, error) {
+	if len(vals) != 3 {
+		return Value{}, errors.New("invalid number of arguments")
+	}
+
+	x, err := numberArg(vals, 0)
+	if err != nil {
+		return Value{}, err
+	}
+
+	lo, err := numberArg(vals, 1)
+	if err != nil {
+		return Value{}, err
+	}
+
+	hi, err := numberArg(vals, 2)
+	if err != nil {
+		return Value{}, err
+	}
+
+	return BoolValue(x.GreaterThanOrEqual(lo) && x.LessThanOrEqual(hi)), nil
+}
+
+// If возвращает then, если условие истинно, иначе otherwise; null в условии считается ложью.
+// Парсер передает ветви лямбдами без параметров, поэтому вычисляется только выбранная ветвь.
+func If(vals ...Value) (Value, error) {
+	if len(vals) != 3 {
+		return Value{}, errors.New("invalid number of arguments")
+	}
+
+	if !vals[0].IsBool() && !vals[0].IsNull() {
+		return Value{}, errors.Errorf("argument 1 is %s, expected bool", vals[0].Kind())
+	}
+
+	branch := vals[2]
+	if vals[0].Bool() {
+		branch = vals[1]
+	}
+
+	if branch.IsFunction() {
+		return branch.Call()
+	}
+
+	return branch, nil
+}
+
+// Coalesce возвращает первый аргумент, отличный от null, или null.
+func Coalesce(vals ...Value) (Value, error) {
+	for _, val := range vals {
+		if !val.IsNull() {
+			return val, nil
+		}
+	}
+
+	return NullValue(), nil
+}
+
+func IsNull(vals ...Value) (Value, error) {
+	if len(vals) != 1 {
+		return Value{}, errors.New("invalid number of arguments")
+	}
+
+	return BoolValue(vals[0].IsNull()), nil
+}
+
+func IfNull(vals ...Value) (Value, error) {
+	if len(vals) != 2 {
+		return Value{}, errors.New("invalid number of arguments")
+	}
+
+	return Coalesce(vals...)
+}
+
+func numberArg(vals []Value, i int) (decimal.Decimal, error) {
+	if !vals[i].IsNumber() {
+		return decimal.Zero, errors.Errorf("argument %d is %s, expected number", i+1, vals[i].Kind())
+	}
+
+	return vals[i].Number(), nil
+}
+
+func intArg(vals []Value, i int) (int, error) {
+	num, err := numberArg(vals, i)
+	if err != nil {
+		return 0, err
+	}
+
+	if !num.IsInteger() || !num.BigInt().IsInt64() {
+		return 0, errors.Errorf("argument %d is %s, expected integer", i+1, num.String())
+	}
+
+	return int(num.IntPart()), nil
+}
+
+func stringArg(vals []Value, i int) (string, error) {
+	if !vals[i].IsString() {
+		return "", errors.Errorf("argument %d is %s, expected string", i+1, vals[i].Kind())
+	}
+
+	return vals[i].Str(), nil
 }
@@ -0,0 +1,369 @@
+package decexpr
+
+import (
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+)
+
+var (
+	secondsPerHour   = decimal.NewFromInt(3600)
+	secondsPerMinute = decimal.NewFromInt(60)
+)
+
+// Date создает дату: date(year, month, day) или date("2006-01-02").
+func Date(vals ...Value) (Value, error) {
+	switch len(vals) {
+	case 1:
+		str, err := stringArg(vals, 0)
+		if err != nil {
+			return Value{}, err
+		}
+
+		t, err := time.Parse(time.DateOnly, strings.TrimSpace(str))
+		if err != nil {
+			return Value{}, errors.Errorf("invalid date %q", str)
+		}
+
+		return DateValue(t), nil
+	case 3:
+		t, err := timeArgs(vals)
+		if err != nil {
+			return Value{}, err
+		}
+
+		return DateValue(t), nil
+	default:
+		return Value{}, errors.New("invalid number of arguments")
+	}
+}
+
+// DateTime создает дату со временем: datetime(year, month, day[, hour[, minute[, second]]])
+// или datetime("2006-01-02T15:04:05Z07:00"). Время без часового пояса считается UTC.
+func DateTime(vals ...Value) (Value, error) {
+	switch len(vals) {
+	case 1:
+		str, err := stringArg(vals, 0)
+		if err != nil {
+			return Value{}, err
+		}
+
+		str = strings.TrimSpace(str)
+		for _, layout := range []string{time.RFC3339Nano, time.DateTime, "2006-01-02T15:04:05"} {
+			if t, err := time.Parse(layout, str); err == nil {
+				return DateTimeValue(t), nil
+			}
+		}
+
+		return Value{}, errors.Errorf("invalid datetime %q", str)
+	case 3, 4, 5, 6:
+		t, err := timeArgs(vals)
+		if err != nil {
+			return Value{}, err
+		}
+
+		return DateTimeValue(t), nil
+	default:
+		return Value{}, errors.New("invalid number of arguments")
+	}
+}
+
+func today(clock time.Time, vals ...Value) (Value, error) {
+	if len(vals) != 0 {
+		return Value{}, errors.New("invalid number of arguments")
+	}
+
+	return DateValue(clock), nil
+}
+
+func now(clock time.Time, vals ...Value) (Value, error) {
+	if len(vals) != 0 {
+		return Value{}, errors.New("invalid number of arguments")
+	}
+
+	return DateTimeValue(clock), nil
+}
+
+// DaysBetween возвращает число календарных дней от первой даты до второй.
+func DaysBetween(vals ...Value) (Value, error) {
+	if len(vals) != 2 {
+		return Value{}, errors.New("invalid number of arguments")
+	}
+
+	t1, err := timeArg(vals, 0)
+	if err != nil {
+		return Value{}, err
+	}
+
+	t2, err := timeArg(vals, 1)
+	if err != nil {
+		return Value{}, err
+	}
+
+	return NumberValue(decimal.NewFromInt(civilDays(t2) - civilDays(t1))), nil
+}
+
+// AddMonths сдвигает дату на n месяцев: add_months(d, n[, eom]).
+// Если в целевом месяце нет такого дня, берется последний день месяца.
+// При eom = true последний день месяца переходит в последний день целевого месяца.
+func AddMonths(vals ...Value) (Value, error) {
+	if len(vals) != 2 && len(vals) != 3 {
+		return Value{}, errors.New("invalid number of arguments")
+	}
+
+	t, err := timeArg(vals, 0)
+	if err != nil {
+		return Value{}, err
+	}
+
+	months, err := intArg(vals, 1)
+	if err != nil {
+		return Value{}, err
+	}
+
+	eom := false
+	if len(vals) == 3 {
+		if !vals[2].IsBool() {
+			return Value{}, errors.Errorf("argument 3 is %s, expected bool", vals[2].Kind())
+		}
+
+		eom = vals[2].Bool()
+	}
+
+	y, m, d := t.Date()
+	target := time.Date(y, m+time.Month(months), 1, 0, 0, 0, 0, time.UTC)
+	last := daysInMonth(target.Year(), target.Month())
+
+	if eom && d == daysInMonth(y, m) {
+		d = last
+	}
+
+	res := time.Date(target.Year(), target.Month(), min(d, last),
+		t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+
+	if vals[0].Kind() == KindDate {
+		return DateValue(res), nil
+	}
+
+	return DateTimeValue(res), nil
+}
+
+// YearFrac возвращает долю года между датами: yearfrac(start, end[, basis]).
+// basis - строка "30/360", "30E/360", "ACT/360", "ACT/365", "ACT/ACT"
+// или код Excel 0-4; по умолчанию 30/360 (US).
+func YearFrac(vals ...Value) (Value, error) {
+	if len(vals) != 2 && len(vals) != 3 {
+		return Value{}, errors.New("invalid number of arguments")
+	}
+
+	start, err := timeArg(vals, 0)
+	if err != nil {
+		return Value{}, err
+	}
+
+	end, err := timeArg(vals, 1)
+	if err != nil {
+		return Value{}, err
+	}
+
+	basis := "30/360"
+	if len(vals) == 3 {
+		basis, err = dayCountBasis(vals[2])
+		if err != nil {
+			return Value{}, err
+		}
+	}
+
+	if civilDays(start) > civilDays(end) {
+		start, end = end, start
+	}
+
+	actual := decimal.NewFromInt(civilDays(end) - civilDays(start))
+
+	switch basis {
+	case "30/360", "30E/360":
+		return NumberValue(decimal.NewFromInt(days360(start, end, basis == "30E/360")).Div(decimal.NewFromInt(360))), nil
+	case "ACT/360":
+		return NumberValue(actual.Div(decimal.NewFromInt(360))), nil
+	case "ACT/365":
+		return NumberValue(actual.Div(decimal.NewFromInt(365))), nil
+	default:
+		return NumberValue(actActISDA(start, end)), nil
+	}
+}
+
+func Days(vals ...Value) (Value, error) {
+	return durationOf(vals, secondsPerDay)
+}
+
+func Hours(vals ...Value) (Value, error) {
+	return durationOf(vals, secondsPerHour)
+}
+
+func Minutes(vals ...Value) (Value, error) {
+	return durationOf(vals, secondsPerMinute)
+}
+
+func Seconds(vals ...Value) (Value, error) {
+	return durationOf(vals, decimal.NewFromInt(1))
+}
+
+func TotalDays(vals ...Value) (Value, error) {
+	return durationIn(vals, secondsPerDay)
+}
+
+func TotalHours(vals ...Value) (Value, error) {
+	return durationIn(vals, secondsPerHour)
+}
+
+func TotalSeconds(vals ...Value) (Value, error) {
+	return durationIn(vals, decimal.NewFromInt(1))
+}
+
+func durationOf(vals []Value, unit decimal.Decimal) (Value, error) {
+	if len(vals) != 1 {
+		return Value{}, errors.New("invalid number of arguments")
+	}
+
+	num, err := numberArg(vals, 0)
+	if err != nil {
+		return Value{}, err
+	}
+
+	return DurationSecondsValue(num.Mul(unit)), nil
+}
+
+func durationIn(vals []Value, unit decimal.Decimal) (Value, error) {
+	if len(vals) != 1 {
+		return Value{}, errors.New("invalid number of arguments")
+	}
+
+	if !vals[0].IsDuration() {
+		return Value{}, errors.Errorf("argument 1 is %s, expected duration", vals[0].Kind())
+	}
+
+	return NumberValue(vals[0].Seconds().Div(unit)), nil
+}
+
+func timeArg(vals []Value, i int) (time.Time, error) {
+	if !vals[i].IsTime() {
+		return time.Time{}, errors.Errorf("argument %d is %s, expected date", i+1, vals[i].Kind())
+	}
+
+	return vals[i].Time(), nil
+}
+
+// timeArgs собирает время из аргументов year, month, day[, hour[, minute[, second]]].
+func timeArgs(vals []Value) (time.Time, error) {
+	parts := [6]int{}
+
+	for i := range vals {
+		part, err := intArg(vals, i)
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		parts[i] = part
+	}
+
+	t := time.Date(parts[0], time.Month(parts[1]), parts[2], parts[3], parts[4], parts[5], 0, time.UTC)
+
+	y, m, d := t.Date()
+	if y != parts[0] || int(m) != parts[1] || d != parts[2] ||
+		t.Hour() != parts[3] || t.Minute() != parts[4] || t.Second() != parts[5] {
+		return time.Time{}, errors.Errorf("invalid date %04d-%02d-%02d %02d:%02d:%02d",
+			parts[0], parts[1], parts[2], parts[3], parts[4], parts[5])
+	}
+
+	return t, nil
+}
+
+func dayCountBasis(val Value) (string, error) {
+	if val.IsNumber() {
+		switch val.Number().String() {
+		case "0":
+			return "30/360", nil
+		case "1":
+			return "ACT/ACT", nil
+		case "2":
+			return "ACT/360", nil
+		case "3":
+			return "ACT/365", nil
+		case "4":
+			return "30E/360", nil
+		}
+	}
+
+	if val.IsString() {
+		basis := strings.ToUpper(strings.TrimSpace(val.Str()))
+		switch basis {
+		case "30/360", "30E/360", "ACT/360", "ACT/365", "ACT/ACT":
+			return basis, nil
+		}
+	}
+
+	return "", errors.Errorf("invalid day count basis %s", val.String())
+}
+
+// civilDays возвращает номер календарного дня даты, отсчитанный от 1970-01-01.
+func civilDays(t time.Time) int64 {
+	y, m, d := t.Date()
+
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC).Unix() / 86400
+}
+
+func daysInMonth(year int, month time.Month) int {
+	return time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+func isLastDayOfFebruary(t time.Time) bool {
+	return t.Month() == time.February && t.Day() == daysInMonth(t.Year(), time.February)
+}
+
+// days360 считает дни по конвенции 30/360: US (NASD, как в Excel) или европейской 30E/360.
+func days360(start, end time.Time, european bool) int64 {
+	y1, m1, d1 := start.Date()
+	y2, m2, d2 := end.Date()
+
+	if european {
+		d1 = min(d1, 30)
+		d2 = min(d2, 30)
+	} else {
+		if isLastDayOfFebruary(start) && isLastDayOfFebruary(end) {
+			d2 = 30
+		}
+
+		if isLastDayOfFebruary(start) {
+			d1 = 30
+		}
+
+		if d2 == 31 && d1 >= 30 {
+			d2 = 30
+		}
+
+		d1 = min(d1, 30)
+	}
+
+	return int64((y2-y1)*360 + (int(m2)-int(m1))*30 + (d2 - d1))
+}
+
+// actActISDA считает долю года ACT/ACT (ISDA): дни каждого календарного года делятся на длину этого года.
+func actActISDA(start, end time.Time) decimal.Decimal {
+	res := decimal.Zero
+
+	for y := start.Year(); y <= end.Year(); y++ {
+		from := max(civilDays(start), civilDays(time.Date(y, 1, 1, 0, 0, 0, 0, time.UTC)))
+		to := min(civilDays(end), civilDays(time.Date(y+1, 1, 1, 0, 0, 0, 0, time.UTC)))
+		yearDays := int64(365)
+
+		if daysInMonth(y, time.February) == 29 {
+			yearDays = 366
+		}
+
+		res = res.Add(decimal.NewFromInt(to - from).Div(decimal.NewFromInt(yearDays)))
+	}
+
+	return res
+}
@@ -0,0 +1,204 @@
+package decexpr
+
+import (
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+)
+
+// Map применяет функцию к каждому элементу списка: map(items, x -> x.price * x.qty).
+func Map(vals ...Value) (Value, error) {
+	list, fn, err := listAndFunction(vals, 2)
+	if err != nil {
+		return Value{}, err
+	}
+
+	res := make([]Value, 0, len(list))
+	for _, item := range list {
+		v, err := fn.Call(item)
+		if err != nil {
+			return Value{}, err
+		}
+
+		res = append(res, v)
+	}
+
+	return ListValue(res...), nil
+}
+
+// Filter оставляет элементы, для которых предикат истинен: filter(items, x -> x.qty > 0).
+func Filter(vals ...Value) (Value, error) {
+	list, fn, err := listAndFunction(vals, 2)
+	if err != nil {
+		return Value{}, err
+	}
+
+	res := make([]Value, 0, len(list))
+	for _, item := range list {
+		ok, err := callPredicate(fn, item)
+		if err != nil {
+			return Value{}, err
+		}
+
+		if ok {
+			res = append(res, item)
+		}
+	}
+
+	return ListValue(res...), nil
+}
+
+// Reduce сворачивает список: reduce(items, (acc, x) -> acc + x, 0).
+func Reduce(vals ...Value) (Value, error) {
+	list, fn, err := listAndFunction(vals, 3)
+	if err != nil {
+		return Value{}, err
+	}
+
+	acc := vals[2]
+	for _, item := range list {
+		acc, err = fn.Call(acc, item)
+		if err != nil {
+			return Value{}, err
+		}
+	}
+
+	return acc, nil
+}
+
+func Any(vals ...Value) (Value, error) {
+	list, fn, err := listAndFunction(vals, 2)
+	if err != nil {
+		return Value{}, err
+	}
+
+	for _, item := range list {
+		ok, err := callPredicate(fn, item)
+		if err != nil {
+			return Value{}, err
+		}
+
+		if ok {
+			return BoolValue(true), nil
+		}
+	}
+
+	return BoolValue(false), nil
+}
+
+func All(vals ...Value) (Value, error) {
+	list, fn, err := listAndFunction(vals, 2)
+	if err != nil {
+		return Value{}, err
+	}
+
+	for _, item := range list {
+		ok, err := callPredicate(fn, item)
+		if err != nil {
+			return Value{}, err
+		}
+
+		if !ok {
+			return BoolValue(false), nil
+		}
+	}
+
+	return BoolValue(true), nil
+}
+
+// SumIf суммирует элементы, для которых предикат истинен: sumif(items, pred[, x -> value]).
+func SumIf(vals ...Value) (Value, error) {
+	if len(vals) != 2 && len(vals) != 3 {
+		return Value{}, errors.New("invalid number of arguments")
+	}
+
+	list, pred, err := listAndFunction(vals[:2], 2)
+	if err != nil {
+		return Value{}, err
+	}
+
+	var valueFn Value
+	if len(vals) == 3 {
+		if !vals[2].IsFunction() {
+			return Value{}, errors.Errorf("argument 3 is %s, expected function", vals[2].Kind())
+		}
+
+		valueFn = vals[2]
+	}
+
+	sum := decimal.Zero
+	for _, item := range list {
+		ok, err := callPredicate(pred, item)
+		if err != nil {
+			return Value{}, err
+		}
+
+		if !ok {
+			continue
+		}
+
+		if valueFn.IsFunction() {
+			item, err = valueFn.Call(item)
+			if err != nil {
+				return Value{}, err
+			}
+		}
+
+		if !item.IsNumber() {
+			return Value{}, errors.Errorf("summed value is %s, expected number", item.Kind())
+		}
+
+		sum = sum.Add(item.Number())
+	}
+
+	return NumberValue(sum), nil
+}
+
+func CountIf(vals ...Value) (Value, error) {
+	list, fn, err := listAndFunction(vals, 2)
+	if err != nil {
+		return Value{}, err
+	}
+
+	count := int64(0)
+	for _, item := range list {
+		ok, err := callPredicate(fn, item)
+		if err != nil {
+			return Value{}, err
+		}
+
+		if ok {
+			count++
+		}
+	}
+
+	return NumberValue(decimal.NewFromInt(count)), nil
+}
+
+func listAndFunction(vals []Value, args int) ([]Value, Value, error) {
+	if len(vals) != args {
+		return nil, Value{}, errors.New("invalid number of arguments")
+	}
+
+	if !vals[0].IsList() {
+		return nil, Value{}, errors.Errorf("argument 1 is %s, expected list", vals[0].Kind())
+	}
+
+	if !vals[1].IsFunction() {
+		return nil, Value{}, errors.Errorf("argument 2 is %s, expected function", vals[1].Kind())
+	}
+
+	return vals[0].List(), vals[1], nil
+}
+
+func callPredicate(fn Value, item Value) (bool, error) {
+	res, err := fn.Call(item)
+	if err != nil {
+		return false, err
+	}
+
+	if !res.IsBool() {
+		return false, errors.Errorf("predicate result is %s, expected bool", res.Kind())
+	}
+
+	return res.Bool(), nil
+}
@@ -0,0 +1,165 @@
+package decexpr
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+)
+
+// Len возвращает длину строки в символах или количество элементов списка.
+func Len(vals ...Value) (Value, error) {
+	if len(vals) != 1 {
+		return Value{}, errors.New("invalid number of arguments")
+	}
+
+	if vals[0].IsList() {
+		return NumberValue(decimal.NewFromInt(int64(len(vals[0].List())))), nil
+	}
+
+	str, err := stringArg(vals, 0)
+	if err != nil {
+		return Value{}, err
+	}
+
+	return NumberValue(decimal.NewFromInt(int64(utf8.RuneCountInString(str)))), nil
+}
+
+func Upper(vals ...Value) (Value, error) {
+	if len(vals) != 1 {
+		return Value{}, errors.New("invalid number of arguments")
+	}
+
+	str, err := stringArg(vals, 0)
+	if err != nil {
+		return Value{}, err
+	}
+
+	return StringValue(strings.ToUpper(str)), nil
+}
+
+func Lower(vals ...Value) (Value, error) {
+	if len(vals) != 1 {
+		return Value{}, errors.New("invalid number of arguments")
+	}
+
+	str, err := stringArg(vals, 0)
+	if err != nil {
+		return Value{}, err
+	}
+
+	return StringValue(strings.ToLower(str)), nil
+}
+
+// Concat склеивает аргументы, приводя числа и логические значения к строке.
+func Concat(vals ...Value) (Value, error) {
+	var buf strings.Builder
+
+	for _, val := range vals {
+		buf.WriteString(val.String())
+	}
+
+	return StringValue(buf.String()), nil
+}
+
+func Contains(vals ...Value) (Value, error) {
+	str, sub, err := stringPair(vals)
+	if err != nil {
+		return Value{}, err
+	}
+
+	return BoolValue(strings.Contains(str, sub)), nil
+}
+
+func StartsWith(vals ...Value) (Value, error) {
+	str, prefix, err := stringPair(vals)
+	if err != nil {
+		return Value{}, err
+	}
+
+	return BoolValue(strings.HasPrefix(str, prefix)), nil
+}
+
+func EndsWith(vals ...Value) (Value, error) {
+	str, suffix, err := stringPair(vals)
+	if err != nil {
+		return Value{}, err
+	}
+
+	return BoolValue(strings.HasSuffix(str, suffix)), nil
+}
+
+// Substr возвращает подстроку substr(s, start[, length]); индексы считаются в символах с нуля.
+func Substr(vals ...Value) (Value, error) {
+	if len(vals) != 2 && len(vals) != 3 {
+		return Value{}, errors.New("invalid number of arguments")
+	}
+
+	str, err := stringArg(vals, 0)
+	if err != nil {
+		return Value{}, err
+	}
+
+	start, err := intArg(vals, 1)
+	if err != nil {
+		return Value{}, err
+	}
+
+	runes := []rune(str)
+	if start < 0 || start > len(runes) {
+		return Value{}, errors.Errorf("start %d is out of range [0, %d]", start, len(runes))
+	}
+
+	end := len(runes)
+	if len(vals) == 3 {
+		length, err := intArg(vals, 2)
+		if err != nil {
+			return Value{}, err
+		}
+
+		if length < 0 {
+			return Value{}, errors.Errorf("length %d is negative", length)
+		}
+
+		end = min(start+length, len(runes))
+	}
+
+	return StringValue(string(runes[start:end])), nil
+}
+
+func ToNumber(vals ...Value) (Value, error) {
+	if len(vals) != 1 {
+		return Value{}, errors.New("invalid number of arguments")
+	}
+
+	str, err := stringArg(vals, 0)
+	if err != nil {
+		return Value{}, err
+	}
+
+	num, err := decimal.NewFromString(strings.TrimSpace(str))
+	if err != nil {
+		return Value{}, errors.Errorf("invalid number %q", str)
+	}
+
+	return NumberValue(num), nil
+}
+
+func stringPair(vals []Value) (string, string, error) {
+	if len(vals) != 2 {
+		return "", "", errors.New("invalid number of arguments")
+	}
+
+	str1, err := stringArg(vals, 0)
+	if err != nil {
+		return "", "", err
+	}
+
+	str2, err := stringArg(vals, 1)
+	if err != nil {
+		return "", "", err
+	}
+
+	return str1, str2, nil
+}
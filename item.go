@@ -1,25 +1,38 @@
 package decexpr
 
 import (
-	"strconv"
-
 	pkgErrors "github.com/pkg/errors"
 	"github.com/shopspring/decimal"
 )
 
-/// Оператор	Описание					Приоритет	Ассоциативность
-//	()			Скобки						0			—
-//	sin, max,..	Функции						5			—
-//	^			Возведение в степень		4			Справа налево
-//	-, +, !		Унарные операции			3			Справа налево
-//	*, /, %		Умножение, деление			2			Слева направо
-//	+, -		Бинарное сложение/вычитание	1			Слева направо
+/// Оператор			Описание					Приоритет	Ассоциативность
+//	()					Скобки						0			—
+//	sin, max,..			Функции						8			—
+//	[], a[i], a.b		Список, индекс, поле		8			—
+//	!					Факториал (постфикс)		8			Слева направо
+//	??					Первое не null значение		8			Слева направо
+//	^					Возведение в степень		7			Справа налево
+//	-, !				Унарные операции			7			Справа налево
+//	*, /, %				Умножение, деление			6			Слева направо
+//	+, -				Бинарное сложение/вычитание	5			Слева направо
+//	==, !=, <, <=, >, >=	Сравнение					4			Слева направо
+//	&&					Логическое И				3			Слева направо
+//	||					Логическое ИЛИ				2			Слева направо
+//
+// ?? связывает сильнее унарных операций: -x ?? 2 означает -(x ?? 2).
 
 type RPNItem struct {
 	Token
 	Priority     int
 	FuncArgCount int
 	Number       decimal.Decimal
+	Lambda       *Lambda
+}
+
+// Lambda - анонимная функция "x -> выражение", тело которой разобрано в отдельную RPN.
+type Lambda struct {
+	Params []string
+	Body   []*RPNItem
 }
 
 func NewRPNItem(token Token) (*RPNItem, error) {
@@ -28,28 +41,29 @@ func NewRPNItem(token Token) (*RPNItem, error) {
 	}
 
 	switch token.Type {
-	case TokenIdent, TokenEOF:
+	case TokenIdent, TokenBool, TokenString, TokenNull, TokenEOF:
 		item.Priority = 0
 	case TokenFloatNumber:
-		value, err := strconv.ParseInt(token.Literal, 10, 64)
+		// литерал - цифры без разделителей, число цифр не ограничено
+		value, err := decimal.NewFromString(token.Literal)
 		if err != nil {
 			return item, err
 		}
 
-		item.Number = decimal.New(value, -int32(token.Exp))
+		item.Number = value.Shift(-int32(token.Exp))
 		item.Priority = 0
 	case TokenIntNumber:
-		value, err := strconv.ParseInt(token.Literal, 10, 64)
+		value, err := decimal.NewFromString(token.Literal)
 		if err != nil {
 			return item, err
 		}
 
-		item.Number = decimal.NewFromInt(value)
-	case TokenFunction:
-		item.Priority = 5
+		item.Number = value
+	case TokenFunction, TokenPostfixOperator, TokenField, TokenList, TokenIndex:
+		item.Priority = 8
 	case TokenUnaryOperator:
-		item.Priority = 4
-	case TokenLeftParen, TokenRightParen:
+		item.Priority = 7
+	case TokenLeftParen, TokenRightParen, TokenLeftBracket, TokenRightBracket:
 		item.Priority = 0
 	case TokenOperator:
 		priority, exists := operatorPriority[token.Literal]
@@ -57,7 +71,7 @@ func NewRPNItem(token Token) (*RPNItem, error) {
 			return item, pkgErrors.Errorf("invalid operator priority: %s", token.Literal)
 		}
 		item.Priority = priority
-	case TokenComma:
+	case TokenComma, TokenArrow, TokenAssign, TokenSemicolon, TokenLet:
 		item.Priority = 0
 	default:
 		panic("unhandled default case")
@@ -75,13 +89,39 @@ const (
 	OpDiv   Operator = "/"
 	OpMod   Operator = "%"
 	OpPower Operator = "^"
+
+	OpEq  Operator = "=="
+	OpNe  Operator = "!="
+	OpLt  Operator = "<"
+	OpLe  Operator = "<="
+	OpGt  Operator = ">"
+	OpGe  Operator = ">="
+	OpAnd Operator = "&&"
+	OpOr  Operator = "||"
+
+	OpCoalesce Operator = "??"
+
+	OpNot       Operator = "!"
+	OpFactorial Operator = "!"
+	OpPercent   Operator = "%"
 )
 
+// operatorPriority - приоритеты бинарных операторов. ?? связывает сильнее арифметики:
+// price * (1 - discount ?? 0) означает price * (1 - (discount ?? 0)).
 var operatorPriority = map[Operator]int{
-	OpAdd:   1,
-	OpSub:   1,
-	OpMul:   2,
-	OpDiv:   2,
-	OpMod:   2,
-	OpPower: 4,
+	OpOr:       2,
+	OpAnd:      3,
+	OpEq:       4,
+	OpNe:       4,
+	OpLt:       4,
+	OpLe:       4,
+	OpGt:       4,
+	OpGe:       4,
+	OpAdd:      5,
+	OpSub:      5,
+	OpMul:      6,
+	OpDiv:      6,
+	OpMod:      6,
+	OpPower:    7,
+	OpCoalesce: 8,
 }
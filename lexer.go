@@ -1,7 +1,8 @@
 package decexpr
 
 import (
-	"math"
+	"strconv"
+	"unicode/utf8"
 
 	pkgErrors "github.com/pkg/errors"
 )
@@ -11,25 +12,74 @@ const (
 	defaultNumberSize = 10
 )
 
+// DefaultMaxLength - максимальная длина выражения в байтах по умолчанию.
+const DefaultMaxLength = 1 << 20
+
 type Lexer struct {
 	input     string
 	position  int  // current position in input (points to current char)
 	ch        byte // current char under examination
 	prevToken Token
+	newline   bool // перед последним токеном был перевод строки
+	percent   bool
+	math      bool
+	locale    Locale
+	maxLength int
+
+	// позиция, до которой посчитаны строка и столбец
+	locPos    int
+	locLine   int
+	locColumn int
+}
+
+type LexerOption func(l *Lexer)
+
+// WithLexerPercent включает проценты: '%' после операнда всегда означает процент, "15%" читается как 0.15,
+// "(a + b)%" - как (a + b) / 100. Остаток от деления в этом режиме вычисляет функция mod().
+func WithLexerPercent() LexerOption {
+	return func(l *Lexer) {
+		l.percent = true
+	}
+}
+
+// WithLexerMathOperators включает обозначения операторов "×", "÷" и "**" (возведение в степень).
+func WithLexerMathOperators() LexerOption {
+	return func(l *Lexer) {
+		l.math = true
+	}
 }
 
-func NewLexer(input string) (*Lexer, error) {
-	if len(input) >= math.MaxInt16 {
-		return nil, pkgErrors.Errorf("input string too long, must be less than %d", math.MaxInt16)
+// WithLexerMaxLength ограничивает длину выражения в байтах; 0 - без ограничения.
+func WithLexerMaxLength(n int) LexerOption {
+	return func(l *Lexer) {
+		l.maxLength = n
 	}
+}
 
+func NewLexer(input string, opts ...LexerOption) (*Lexer, error) {
 	l := &Lexer{
 		input: input,
 		prevToken: Token{
 			Type:    TokenEOF,
 			Literal: "",
 		},
-		position: -1,
+		position:  -1,
+		locale:    DefaultLocale,
+		maxLength: DefaultMaxLength,
+		locLine:   1,
+		locColumn: 1,
+	}
+
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	if l.maxLength > 0 && len(input) > l.maxLength {
+		return nil, pkgErrors.Errorf("input string too long: %d bytes, maximum %d", len(input), l.maxLength)
+	}
+
+	if err := l.locale.validate(); err != nil {
+		return nil, err
 	}
 
 	l.nextChar()
@@ -38,34 +88,92 @@ func NewLexer(input string) (*Lexer, error) {
 }
 
 func (l *Lexer) NextToken() Token {
+	tok := l.nextToken()
+	tok.Line, tok.Column = l.locate(tok.Position)
+
+	return tok
+}
+
+// locate возвращает строку и столбец позиции. Позиции токенов возрастают,
+// поэтому подсчет продолжается с предыдущей позиции.
+func (l *Lexer) locate(pos int) (int, int) {
+	pos = min(pos, len(l.input))
+
+	for ; l.locPos < pos; l.locPos++ {
+		switch ch := l.input[l.locPos]; {
+		case ch == '\n':
+			l.locLine++
+			l.locColumn = 1
+		case !utf8.RuneStart(ch):
+		default:
+			l.locColumn++
+		}
+	}
+
+	return l.locLine, l.locColumn
+}
+
+func (l *Lexer) nextToken() Token {
 	var tok Token
 
-	l.skipWhitespace()
+	if pos := l.skipWhitespace(); pos >= 0 {
+		tok = newToken(TokenIllegal, "/*", pos)
+		l.prevToken = tok
 
-	//charType :=
-	switch charMap[l.ch] {
-	case CharOperator:
-		if l.ch == '-' {
-			prevTokenType := l.prevToken.Type
-			if prevTokenType == TokenEOF ||
-				prevTokenType == TokenLeftParen ||
-				prevTokenType == TokenComma ||
-				prevTokenType == TokenOperator {
-				tok = newToken(TokenUnaryOperator, string(l.ch), l.position)
-			} else {
-				tok = newToken(TokenOperator, string(l.ch), l.position)
-			}
+		return tok
+	}
 
-			break
-		}
+	charType := charMap[l.ch]
+	if _, ok := l.letterAt(l.position); ok {
+		charType = CharLetter
+	}
 
-		tok = newToken(TokenOperator, string(l.ch), l.position)
+	switch charType {
+	case CharOperator:
+		tok = l.readOperator()
 	case CharLeftParen:
 		tok = newToken(TokenLeftParen, string(l.ch), l.position)
 	case CharRightParen:
 		tok = newToken(TokenRightParen, string(l.ch), l.position)
+	case CharLeftBracket:
+		tok = newToken(TokenLeftBracket, string(l.ch), l.position)
+	case CharRightBracket:
+		tok = newToken(TokenRightBracket, string(l.ch), l.position)
 	case CharComma:
+		if l.locale.ArgumentSeparator != ',' {
+			tok = newToken(TokenIllegal, string(l.ch), l.position)
+
+			break
+		}
+
 		tok = newToken(TokenComma, string(l.ch), l.position)
+	case CharSemicolon:
+		if l.locale.ArgumentSeparator == ';' {
+			tok = newToken(TokenComma, string(l.ch), l.position)
+
+			break
+		}
+
+		tok = newToken(TokenSemicolon, string(l.ch), l.position)
+	case CharQuote:
+		tok = l.readString()
+	case CharDot:
+		// доступ к полю результата выражения: items[0].price
+		if _, ok := l.letterAt(l.position + 1); !ok || !l.afterOperand() {
+			tok = newToken(TokenIllegal, string(l.ch), l.position)
+
+			break
+		}
+
+		l.nextChar()
+
+		tok = l.readIdentifier()
+		tok.Type = TokenField
+		tok.Position--
+
+		l.prevToken = tok
+
+		return tok
 	case CharDigit:
 		tok = l.readNumber()
 
@@ -76,6 +184,10 @@ func (l *Lexer) NextToken() Token {
 		tok = l.readIdentifier()
 		if l.ch == '(' {
 			tok.Type = TokenFunction
+		} else if tok.Literal == "true" || tok.Literal == "false" {
+			tok.Type = TokenBool
+		} else if tok.Literal == "null" {
+			tok.Type = TokenNull
 		}
 
 		l.prevToken = tok
@@ -84,7 +196,18 @@ func (l *Lexer) NextToken() Token {
 	case CharEOF:
 		tok = newToken(TokenEOF, "", l.position)
 	default:
-		tok = newToken(TokenIllegal, string(l.ch), l.position)
+		if op, ok := l.mathOperator(); ok {
+			tok = newToken(TokenOperator, op, l.position)
+			// "×" и "÷" занимают в UTF-8 два байта
+			l.nextChar()
+
+			break
+		}
+
+		// символ вне ASCII занимает несколько байт и выводится в ошибке целиком
+		_, size := utf8.DecodeRuneInString(l.input[l.position:])
+		tok = newToken(TokenIllegal, l.input[l.position:l.position+size], l.position)
+		l.skipBytes(size - 1)
 	}
 
 	l.nextChar()
@@ -99,7 +222,7 @@ func (l *Lexer) Tokenize() ([]Token, error) {
 
 	for token := l.NextToken(); token.Type != TokenEOF; token = l.NextToken() {
 		if token.Type == TokenIllegal {
-			return nil, pkgErrors.Errorf("invalid token type: %s, position:%d", token.Literal, l.position)
+			return nil, pkgErrors.Errorf("invalid token type: %s, %s", token.Literal, token.location())
 		}
 
 		tokens = append(tokens, token)
@@ -118,44 +241,223 @@ func (l *Lexer) nextChar() {
 	}
 }
 
+func (l *Lexer) peekChar() byte {
+	if l.position+1 >= len(l.input) {
+		return 0
+	}
+
+	return l.input[l.position+1]
+}
+
+// afterOperand сообщает, завершает ли предыдущий токен операнд:
+// от этого зависит, является ли '-' или '!' унарным, бинарным или постфиксным.
+func (l *Lexer) afterOperand() bool {
+	return endsOperand(l.prevToken.Type)
+}
+
+// readOperator читает одно- и двухсимвольные операторы, оставляя l.ch на последнем символе оператора.
+func (l *Lexer) readOperator() Token {
+	beginPos := l.position
+	ch := l.ch
+
+	if ch == '*' && l.math && l.peekChar() == '*' {
+		l.nextChar()
+
+		return newToken(TokenOperator, OpPower, beginPos)
+	}
+
+	switch ch {
+	case '=', '!', '<', '>':
+		if l.peekChar() == '=' {
+			l.nextChar()
+
+			return newToken(TokenOperator, string(ch)+"=", beginPos)
+		}
+	case '&', '|', '?':
+		if l.peekChar() == ch {
+			l.nextChar()
+
+			return newToken(TokenOperator, string(ch)+string(ch), beginPos)
+		}
+
+		return newToken(TokenIllegal, string(ch), beginPos)
+	}
+
+	switch ch {
+	case '=':
+		return newToken(TokenAssign, string(ch), beginPos)
+	case '-':
+		if l.peekChar() == '>' {
+			l.nextChar()
+
+			return newToken(TokenArrow, "->", beginPos)
+		}
+
+		if !l.afterOperand() {
+			return newToken(TokenUnaryOperator, string(ch), beginPos)
+		}
+	case '!':
+		if l.afterOperand() {
+			return newToken(TokenPostfixOperator, string(ch), beginPos)
+		}
+
+		return newToken(TokenUnaryOperator, string(ch), beginPos)
+	case '%':
+		if l.percent && l.afterOperand() {
+			return newToken(TokenPostfixOperator, string(ch), beginPos)
+		}
+	}
+
+	return newToken(TokenOperator, string(ch), beginPos)
+}
+
 func (l *Lexer) readIdentifier() Token {
 	beginPos := l.position
 	buf := make([]byte, 0, defaultIdentSize)
 
-	for isLetter(l.ch) || isDigit(l.ch) {
-		buf = append(buf, l.ch)
+	for {
+		if isDigit(l.ch) {
+			buf = append(buf, l.ch)
+			l.nextChar()
+
+			continue
+		}
+
+		if size, ok := l.identPartAt(l.position); ok {
+			buf = append(buf, l.input[l.position:l.position+size]...)
+			l.skipBytes(size)
+
+			continue
+		}
+
+		// точка внутри идентификатора - доступ к полю записи: item.qty
+		if _, ok := l.letterAt(l.position + 1); ok && isDot(l.ch) {
+			buf = append(buf, l.ch)
+			l.nextChar()
+
+			continue
+		}
+
+		return newToken(TokenIdent, string(buf), beginPos)
+	}
+}
+
+// letterAt сообщает, начинается ли с позиции pos буква идентификатора (в том числе Unicode,
+// например кириллица), и возвращает ее длину в байтах.
+func (l *Lexer) letterAt(pos int) (int, bool) {
+	if pos < 0 || pos >= len(l.input) {
+		return 0, false
+	}
+
+	if l.input[pos] < utf8.RuneSelf {
+		return 1, isLetter(l.input[pos])
+	}
+
+	r, size := utf8.DecodeRuneInString(l.input[pos:])
+
+	return size, isIdentRune(r, true)
+}
+
+// identPartAt - как letterAt, но для продолжения идентификатора: допускает также комбинируемые знаки и цифры.
+func (l *Lexer) identPartAt(pos int) (int, bool) {
+	if pos < 0 || pos >= len(l.input) || l.input[pos] < utf8.RuneSelf {
+		return l.letterAt(pos)
+	}
+
+	r, size := utf8.DecodeRuneInString(l.input[pos:])
+
+	return size, isIdentRune(r, false)
+}
+
+func (l *Lexer) skipBytes(n int) {
+	for range n {
+		l.nextChar()
+	}
+}
+
+// readString читает строковый литерал в одинарных или двойных кавычках,
+// оставляя l.ch на закрывающей кавычке. Literal содержит строку с раскрытыми escape-последовательностями.
+func (l *Lexer) readString() Token {
+	beginPos := l.position
+	quote := l.ch
+	buf := make([]byte, 0, defaultIdentSize)
+
+	for l.nextChar(); l.ch != quote; l.nextChar() {
+		if l.position >= len(l.input) {
+			return newToken(TokenIllegal, l.input[beginPos:], beginPos)
+		}
+
+		if l.ch != '\\' {
+			buf = append(buf, l.ch)
+
+			continue
+		}
 
 		l.nextChar()
+
+		switch l.ch {
+		case '\\', '"', '\'':
+			buf = append(buf, l.ch)
+		case 'n':
+			buf = append(buf, '\n')
+		case 't':
+			buf = append(buf, '\t')
+		case 'r':
+			buf = append(buf, '\r')
+		case 'u':
+			if l.position+4 >= len(l.input) {
+				return newToken(TokenIllegal, l.input[beginPos:], beginPos)
+			}
+
+			code, err := strconv.ParseUint(l.input[l.position+1:l.position+5], 16, 32)
+			if err != nil {
+				return newToken(TokenIllegal, l.input[beginPos:l.position+5], beginPos)
+			}
+
+			buf = utf8.AppendRune(buf, rune(code))
+			l.position += 4
+		default:
+			return newToken(TokenIllegal, l.input[beginPos:min(l.position+1, len(l.input))], beginPos)
+		}
 	}
 
-	return newToken(TokenIdent, string(buf), beginPos)
+	return newToken(TokenString, string(buf), beginPos)
 }
 
 func (l *Lexer) readNumber() Token {
 	beginPos := l.position
-	exp := int16(0)
-	///decimalFound := false
+	exp := 0
+	groupDigits := 0
+	grouped := false
 
 	digits := make([]byte, 0, defaultNumberSize)
 	tokenType := TokenIntNumber
 
-	for isDigit(l.ch) || isDot(l.ch) {
-		if l.ch == '.' {
+loop:
+	for {
+		switch {
+		case isDigit(l.ch):
+			digits = append(digits, l.ch)
+			groupDigits++
+
 			if tokenType == TokenFloatNumber {
-				break
+				exp++
 			}
-
+		case l.ch == l.locale.DecimalSeparator && tokenType != TokenFloatNumber:
 			tokenType = TokenFloatNumber
-			l.nextChar()
-
-			continue
+		case l.ch == l.locale.GroupSeparator && l.ch != 0 && tokenType != TokenFloatNumber &&
+			(groupDigits == 3 || !grouped && groupDigits < 3) && l.groupFollows():
+			grouped = true
+			groupDigits = 0
+		default:
+			break loop
 		}
 
-		digits = append(digits, l.ch)
+		l.nextChar()
+	}
 
-		if tokenType == TokenFloatNumber {
-			exp++
-		}
+	if l.percent && l.ch == '%' {
+		exp += 2
 
 		l.nextChar()
 	}
@@ -166,8 +468,82 @@ func (l *Lexer) readNumber() Token {
 	return tok
 }
 
-func (l *Lexer) skipWhitespace() {
-	for isWhitespace(l.ch) {
-		l.nextChar()
+// groupFollows сообщает, что за разделителем групп следуют ровно три цифры.
+func (l *Lexer) groupFollows() bool {
+	pos := l.position + 1
+	if pos+3 > len(l.input) {
+		return false
+	}
+
+	for _, ch := range []byte(l.input[pos : pos+3]) {
+		if !isDigit(ch) {
+			return false
+		}
+	}
+
+	return pos+3 == len(l.input) || !isDigit(l.input[pos+3])
+}
+
+// mathOperator распознает "×" (U+00D7) и "÷" (U+00F7) в режиме математических обозначений.
+func (l *Lexer) mathOperator() (Operator, bool) {
+	if !l.math || l.ch != 0xC3 {
+		return "", false
+	}
+
+	switch l.peekChar() {
+	case 0x97:
+		return OpMul, true
+	case 0xB7:
+		return OpDiv, true
+	default:
+		return "", false
+	}
+}
+
+// skipWhitespace пропускает пробелы и комментарии #, // и /* */.
+// Возвращает позицию незакрытого комментария /* или -1.
+func (l *Lexer) skipWhitespace() int {
+	l.newline = false
+
+	for {
+		switch {
+		case isWhitespace(l.ch):
+			if l.ch == '\n' {
+				l.newline = true
+			}
+
+			l.nextChar()
+		case l.ch == '#' || (l.ch == '/' && l.peekChar() == '/'):
+			for l.ch != '\n' && l.position < len(l.input) {
+				l.nextChar()
+			}
+		case l.ch == '/' && l.peekChar() == '*':
+			beginPos := l.position
+
+			l.nextChar()
+			l.nextChar()
+
+			for !(l.ch == '*' && l.peekChar() == '/') {
+				if l.position >= len(l.input) {
+					return beginPos
+				}
+
+				if l.ch == '\n' {
+					l.newline = true
+				}
+
+				l.nextChar()
+			}
+
+			l.nextChar()
+			l.nextChar()
+		default:
+			return -1
+		}
 	}
 }
+
+// NewlineBefore сообщает, был ли перевод строки перед последним прочитанным токеном.
+func (l *Lexer) NewlineBefore() bool {
+	return l.newline
+}
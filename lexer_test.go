@@ -27,111 +27,181 @@ func TestLexer_Tokenize(t *testing.T) {
 		{
 			expr: "5+10",
 			tokens: []Token{
-				{Type: TokenFloatNumber, Literal: "5", Exp: 0, Position: 0},
-				{Type: TokenOperator, Literal: "+", Exp: 0, Position: 1},
-				{Type: TokenFloatNumber, Literal: "10", Exp: 0, Position: 2},
+				{Type: TokenFloatNumber, Literal: "5", Exp: 0, Position: 0, Line: 1, Column: 1},
+				{Type: TokenOperator, Literal: "+", Exp: 0, Position: 1, Line: 1, Column: 2},
+				{Type: TokenFloatNumber, Literal: "10", Exp: 0, Position: 2, Line: 1, Column: 3},
 			},
 		},
 		{
 			expr: "var1 + var2(1,432.5)",
 			tokens: []Token{
-				{Type: TokenIdent, Literal: "var1", Exp: 0, Position: 0},
-				{Type: TokenOperator, Literal: "+", Exp: 0, Position: 5},
-				{Type: TokenFunction, Literal: "var2", Exp: 0, Position: 7},
-				{Type: TokenLeftParen, Literal: "(", Exp: 0, Position: 11},
-				{Type: TokenFloatNumber, Literal: "1", Exp: 0, Position: 12},
-				{Type: TokenComma, Literal: ",", Exp: 0, Position: 13},
-				{Type: TokenFloatNumber, Literal: "4325", Exp: 1, Position: 14},
-				{Type: TokenRightParen, Literal: ")", Exp: 0, Position: 19},
+				{Type: TokenIdent, Literal: "var1", Exp: 0, Position: 0, Line: 1, Column: 1},
+				{Type: TokenOperator, Literal: "+", Exp: 0, Position: 5, Line: 1, Column: 6},
+				{Type: TokenFunction, Literal: "var2", Exp: 0, Position: 7, Line: 1, Column: 8},
+				{Type: TokenLeftParen, Literal: "(", Exp: 0, Position: 11, Line: 1, Column: 12},
+				{Type: TokenFloatNumber, Literal: "1", Exp: 0, Position: 12, Line: 1, Column: 13},
+				{Type: TokenComma, Literal: ",", Exp: 0, Position: 13, Line: 1, Column: 14},
+				{Type: TokenFloatNumber, Literal: "4325", Exp: 1, Position: 14, Line: 1, Column: 15},
+				{Type: TokenRightParen, Literal: ")", Exp: 0, Position: 19, Line: 1, Column: 20},
 			},
 		},
 		{
 			expr: "5 + 10",
 			tokens: []Token{
-				{Type: TokenFloatNumber, Literal: "5", Exp: 0, Position: 0},
-				{Type: TokenOperator, Literal: "+", Exp: 0, Position: 2},
-				{Type: TokenFloatNumber, Literal: "10", Exp: 0, Position: 4},
+				{Type: TokenFloatNumber, Literal: "5", Exp: 0, Position: 0, Line: 1, Column: 1},
+				{Type: TokenOperator, Literal: "+", Exp: 0, Position: 2, Line: 1, Column: 3},
+				{Type: TokenFloatNumber, Literal: "10", Exp: 0, Position: 4, Line: 1, Column: 5},
 			},
 		},
 		{
 			expr: "5-10",
 			tokens: []Token{
-				{Type: TokenFloatNumber, Literal: "5", Exp: 0, Position: 0},
-				{Type: TokenOperator, Literal: "-", Exp: 0, Position: 1},
-				{Type: TokenFloatNumber, Literal: "10", Exp: 0, Position: 2},
+				{Type: TokenFloatNumber, Literal: "5", Exp: 0, Position: 0, Line: 1, Column: 1},
+				{Type: TokenOperator, Literal: "-", Exp: 0, Position: 1, Line: 1, Column: 2},
+				{Type: TokenFloatNumber, Literal: "10", Exp: 0, Position: 2, Line: 1, Column: 3},
 			},
 		},
 		{
 			expr: "5.10",
 			tokens: []Token{
-				{Type: TokenFloatNumber, Literal: "510", Exp: 2, Position: 0},
+				{Type: TokenFloatNumber, Literal: "510", Exp: 2, Position: 0, Line: 1, Column: 1},
 			},
 		},
 		{
 			expr: "-5.10",
 			tokens: []Token{
-				{Type: TokenUnaryOperator, Literal: "-", Exp: 0, Position: 0},
-				{Type: TokenFloatNumber, Literal: "510", Exp: 2, Position: 1},
+				{Type: TokenUnaryOperator, Literal: "-", Exp: 0, Position: 0, Line: 1, Column: 1},
+				{Type: TokenFloatNumber, Literal: "510", Exp: 2, Position: 1, Line: 1, Column: 2},
 			},
 		},
 		{
 			expr: "-var1  * (-10+log(var2, 10.123)-max(-45.56))",
 			tokens: []Token{
-				{Type: TokenUnaryOperator, Literal: "-", Exp: 0, Position: 0},
-				{Type: TokenIdent, Literal: "var1", Exp: 0, Position: 1},
-				{Type: TokenOperator, Literal: "*", Exp: 0, Position: 7},
-				{Type: TokenLeftParen, Literal: "(", Exp: 0, Position: 9},
-				{Type: TokenUnaryOperator, Literal: "-", Exp: 0, Position: 10},
-				{Type: TokenFloatNumber, Literal: "10", Exp: 0, Position: 11},
-				{Type: TokenOperator, Literal: "+", Exp: 0, Position: 13},
-				{Type: TokenFunction, Literal: "log", Exp: 0, Position: 14},
-				{Type: TokenLeftParen, Literal: "(", Exp: 0, Position: 17},
-				{Type: TokenIdent, Literal: "var2", Exp: 0, Position: 18},
-				{Type: TokenComma, Literal: ",", Exp: 0, Position: 22},
-				{Type: TokenFloatNumber, Literal: "10123", Exp: 3, Position: 24},
-				{Type: TokenRightParen, Literal: ")", Exp: 0, Position: 30},
-				{Type: TokenOperator, Literal: "-", Exp: 0, Position: 31},
-				{Type: TokenFunction, Literal: "max", Exp: 0, Position: 32},
-				{Type: TokenLeftParen, Literal: "(", Exp: 0, Position: 35},
-				{Type: TokenUnaryOperator, Literal: "-", Exp: 0, Position: 36},
-				{Type: TokenFloatNumber, Literal: "4556", Exp: 2, Position: 37},
-				{Type: TokenRightParen, Literal: ")", Exp: 0, Position: 42},
-				{Type: TokenRightParen, Literal: ")", Exp: 0, Position: 43},
+				{Type: TokenUnaryOperator, Literal: "-", Exp: 0, Position: 0, Line: 1, Column: 1},
+				{Type: TokenIdent, Literal: "var1", Exp: 0, Position: 1, Line: 1, Column: 2},
+				{Type: TokenOperator, Literal: "*", Exp: 0, Position: 7, Line: 1, Column: 8},
+				{Type: TokenLeftParen, Literal: "(", Exp: 0, Position: 9, Line: 1, Column: 10},
+				{Type: TokenUnaryOperator, Literal: "-", Exp: 0, Position: 10, Line: 1, Column: 11},
+				{Type: TokenFloatNumber, Literal: "10", Exp: 0, Position: 11, Line: 1, Column: 12},
+				{Type: TokenOperator, Literal: "+", Exp: 0, Position: 13, Line: 1, Column: 14},
+				{Type: TokenFunction, Literal: "log", Exp: 0, Position: 14, Line: 1, Column: 15},
+				{Type: TokenLeftParen, Literal: "(", Exp: 0, Position: 17, Line: 1, Column: 18},
+				{Type: TokenIdent, Literal: "var2", Exp: 0, Position: 18, Line: 1, Column: 19},
+				{Type: TokenComma, Literal: ",", Exp: 0, Position: 22, Line: 1, Column: 23},
+				{Type: TokenFloatNumber, Literal: "10123", Exp: 3, Position: 24, Line: 1, Column: 25},
+				{Type: TokenRightParen, Literal: ")", Exp: 0, Position: 30, Line: 1, Column: 31},
+				{Type: TokenOperator, Literal: "-", Exp: 0, Position: 31, Line: 1, Column: 32},
+				{Type: TokenFunction, Literal: "max", Exp: 0, Position: 32, Line: 1, Column: 33},
+				{Type: TokenLeftParen, Literal: "(", Exp: 0, Position: 35, Line: 1, Column: 36},
+				{Type: TokenUnaryOperator, Literal: "-", Exp: 0, Position: 36, Line: 1, Column: 37},
+				{Type: TokenFloatNumber, Literal: "4556", Exp: 2, Position: 37, Line: 1, Column: 38},
+				{Type: TokenRightParen, Literal: ")", Exp: 0, Position: 42, Line: 1, Column: 43},
+				{Type: TokenRightParen, Literal: ")", Exp: 0, Position: 43, Line: 1, Column: 44},
 			},
 		},
 		{
 			expr: "5 + -10",
 			tokens: []Token{
-				{Type: TokenFloatNumber, Literal: "5", Exp: 0, Position: 0},
-				{Type: TokenOperator, Literal: "+", Exp: 0, Position: 2},
-				{Type: TokenUnaryOperator, Literal: "-", Exp: 0, Position: 4},
-				{Type: TokenFloatNumber, Literal: "10", Exp: 0, Position: 5},
+				{Type: TokenFloatNumber, Literal: "5", Exp: 0, Position: 0, Line: 1, Column: 1},
+				{Type: TokenOperator, Literal: "+", Exp: 0, Position: 2, Line: 1, Column: 3},
+				{Type: TokenUnaryOperator, Literal: "-", Exp: 0, Position: 4, Line: 1, Column: 5},
+				{Type: TokenFloatNumber, Literal: "10", Exp: 0, Position: 5, Line: 1, Column: 6},
 			},
 		},
 		{
 			expr: "5+var1",
 			tokens: []Token{
-				{Type: TokenFloatNumber, Literal: "5", Exp: 0, Position: 0},
-				{Type: TokenOperator, Literal: "+", Exp: 0, Position: 1},
-				{Type: TokenIdent, Literal: "var1", Exp: 0, Position: 2},
+				{Type: TokenFloatNumber, Literal: "5", Exp: 0, Position: 0, Line: 1, Column: 1},
+				{Type: TokenOperator, Literal: "+", Exp: 0, Position: 1, Line: 1, Column: 2},
+				{Type: TokenIdent, Literal: "var1", Exp: 0, Position: 2, Line: 1, Column: 3},
 			},
 		},
 		{
 			expr: "3 + 4 * 2 / (1 - 5)^2",
 			tokens: []Token{
-				{Type: TokenFloatNumber, Literal: "3", Exp: 0, Position: 0},
-				{Type: TokenOperator, Literal: "+", Exp: 0, Position: 2},
-				{Type: TokenFloatNumber, Literal: "4", Exp: 0, Position: 4},
-				{Type: TokenOperator, Literal: "*", Exp: 0, Position: 6},
-				{Type: TokenFloatNumber, Literal: "2", Exp: 0, Position: 8},
-				{Type: TokenOperator, Literal: "/", Exp: 0, Position: 10},
-				{Type: TokenLeftParen, Literal: "(", Exp: 0, Position: 12},
-				{Type: TokenFloatNumber, Literal: "1", Exp: 0, Position: 13},
-				{Type: TokenOperator, Literal: "-", Exp: 0, Position: 15},
-				{Type: TokenFloatNumber, Literal: "5", Exp: 0, Position: 17},
-				{Type: TokenRightParen, Literal: ")", Exp: 0, Position: 18},
-				{Type: TokenOperator, Literal: "^", Exp: 0, Position: 19},
-				{Type: TokenFloatNumber, Literal: "2", Exp: 0, Position: 20},
+				{Type: TokenFloatNumber, Literal: "3", Exp: 0, Position: 0, Line: 1, Column: 1},
+				{Type: TokenOperator, Literal: "+", Exp: 0, Position: 2, Line: 1, Column: 3},
+				{Type: TokenFloatNumber, Literal: "4", Exp: 0, Position: 4, Line: 1, Column: 5},
+				{Type: TokenOperator, Literal: "*", Exp: 0, Position: 6, Line: 1, Column: 7},
+				{Type: TokenFloatNumber, Literal: "2", Exp: 0, Position: 8, Line: 1, Column: 9},
+				{Type: TokenOperator, Literal: "/", Exp: 0, Position: 10, Line: 1, Column: 11},
+				{Type: TokenLeftParen, Literal: "(", Exp: 0, Position: 12, Line: 1, Column: 13},
+				{Type: TokenFloatNumber, Literal: "1", Exp: 0, Position: 13, Line: 1, Column: 14},
+				{Type: TokenOperator, Literal: "-", Exp: 0, Position: 15, Line: 1, Column: 16},
+				{Type: TokenFloatNumber, Literal: "5", Exp: 0, Position: 17, Line: 1, Column: 18},
+				{Type: TokenRightParen, Literal: ")", Exp: 0, Position: 18, Line: 1, Column: 19},
+				{Type: TokenOperator, Literal: "^", Exp: 0, Position: 19, Line: 1, Column: 20},
+				{Type: TokenFloatNumber, Literal: "2", Exp: 0, Position: 20, Line: 1, Column: 21},
+			},
+		},
+		{
+			expr: "a>=1&&!b||c!=true",
+			tokens: []Token{
+				{Type: TokenIdent, Literal: "a", Exp: 0, Position: 0, Line: 1, Column: 1},
+				{Type: TokenOperator, Literal: ">=", Exp: 0, Position: 1, Line: 1, Column: 2},
+				{Type: TokenFloatNumber, Literal: "1", Exp: 0, Position: 3, Line: 1, Column: 4},
+				{Type: TokenOperator, Literal: "&&", Exp: 0, Position: 4, Line: 1, Column: 5},
+				{Type: TokenUnaryOperator, Literal: "!", Exp: 0, Position: 6, Line: 1, Column: 7},
+				{Type: TokenIdent, Literal: "b", Exp: 0, Position: 7, Line: 1, Column: 8},
+				{Type: TokenOperator, Literal: "||", Exp: 0, Position: 8, Line: 1, Column: 9},
+				{Type: TokenIdent, Literal: "c", Exp: 0, Position: 10, Line: 1, Column: 11},
+				{Type: TokenOperator, Literal: "!=", Exp: 0, Position: 11, Line: 1, Column: 12},
+				{Type: TokenBool, Literal: "true", Exp: 0, Position: 13, Line: 1, Column: 14},
+			},
+		},
+		{
+			expr: `code == "A\"B" + 'c'`,
+			tokens: []Token{
+				{Type: TokenIdent, Literal: "code", Exp: 0, Position: 0, Line: 1, Column: 1},
+				{Type: TokenOperator, Literal: "==", Exp: 0, Position: 5, Line: 1, Column: 6},
+				{Type: TokenString, Literal: `A"B`, Exp: 0, Position: 8, Line: 1, Column: 9},
+				{Type: TokenOperator, Literal: "+", Exp: 0, Position: 15, Line: 1, Column: 16},
+				{Type: TokenString, Literal: "c", Exp: 0, Position: 17, Line: 1, Column: 18},
+			},
+		},
+		{
+			expr: "map(a.b, x -> x[0].c)",
+			tokens: []Token{
+				{Type: TokenFunction, Literal: "map", Exp: 0, Position: 0, Line: 1, Column: 1},
+				{Type: TokenLeftParen, Literal: "(", Exp: 0, Position: 3, Line: 1, Column: 4},
+				{Type: TokenIdent, Literal: "a.b", Exp: 0, Position: 4, Line: 1, Column: 5},
+				{Type: TokenComma, Literal: ",", Exp: 0, Position: 7, Line: 1, Column: 8},
+				{Type: TokenIdent, Literal: "x", Exp: 0, Position: 9, Line: 1, Column: 10},
+				{Type: TokenArrow, Literal: "->", Exp: 0, Position: 11, Line: 1, Column: 12},
+				{Type: TokenIdent, Literal: "x", Exp: 0, Position: 14, Line: 1, Column: 15},
+				{Type: TokenLeftBracket, Literal: "[", Exp: 0, Position: 15, Line: 1, Column: 16},
+				{Type: TokenFloatNumber, Literal: "0", Exp: 0, Position: 16, Line: 1, Column: 17},
+				{Type: TokenRightBracket, Literal: "]", Exp: 0, Position: 17, Line: 1, Column: 18},
+				{Type: TokenField, Literal: "c", Exp: 0, Position: 18, Line: 1, Column: 19},
+				{Type: TokenRightParen, Literal: ")", Exp: 0, Position: 20, Line: 1, Column: 21},
+			},
+		},
+		{
+			expr: "5!",
+			tokens: []Token{
+				{Type: TokenFloatNumber, Literal: "5", Exp: 0, Position: 0, Line: 1, Column: 1},
+				{Type: TokenPostfixOperator, Literal: "!", Exp: 0, Position: 1, Line: 1, Column: 2},
+			},
+		},
+		{
+			expr: "a /* x */ - 1 # tax\n// rate\n/ b",
+			tokens: []Token{
+				{Type: TokenIdent, Literal: "a", Exp: 0, Position: 0, Line: 1, Column: 1},
+				{Type: TokenOperator, Literal: "-", Exp: 0, Position: 10, Line: 1, Column: 11},
+				{Type: TokenFloatNumber, Literal: "1", Exp: 0, Position: 12, Line: 1, Column: 13},
+				{Type: TokenOperator, Literal: "/", Exp: 0, Position: 28, Line: 3, Column: 1},
+				{Type: TokenIdent, Literal: "b", Exp: 0, Position: 30, Line: 3, Column: 3},
+			},
+		},
+		{
+			expr: "цена*сумма(x.кол)",
+			tokens: []Token{
+				{Type: TokenIdent, Literal: "цена", Exp: 0, Position: 0, Line: 1, Column: 1},
+				{Type: TokenOperator, Literal: "*", Exp: 0, Position: 8, Line: 1, Column: 5},
+				{Type: TokenFunction, Literal: "сумма", Exp: 0, Position: 9, Line: 1, Column: 6},
+				{Type: TokenLeftParen, Literal: "(", Exp: 0, Position: 19, Line: 1, Column: 11},
+				{Type: TokenIdent, Literal: "x.кол", Exp: 0, Position: 20, Line: 1, Column: 12},
+				{Type: TokenRightParen, Literal: ")", Exp: 0, Position: 28, Line: 1, Column: 17},
 			},
 		},
 	}
@@ -167,6 +237,6 @@ func BenchmarkLexer_Tokenize(b *testing.B) {
 			b.Fatal(err)
 		}
 
-		lst = lst
+		_ = lst
 	}
 }
@@ -0,0 +1,88 @@
+package decexpr
+
+import (
+	"math"
+
+	"github.com/shopspring/decimal"
+)
+
+// Limits - ограничения ресурсов разбора и вычисления для выражений из недоверенных источников.
+// Нулевое значение поля отключает ограничение.
+type Limits struct {
+	// MaxDepth - вложенность скобок, вызовов функций и списков.
+	MaxDepth int
+	// MaxRPNLength - число элементов разобранного выражения, включая тела лямбд.
+	MaxRPNLength int
+	// MaxSteps - число шагов вычисления, включая тела лямбд и пользовательских функций.
+	MaxSteps int
+	// MaxDigits - число значащих цифр в литерале и в результате операции.
+	MaxDigits int
+	// MaxExponent - модуль показателя степени в операторе ^.
+	MaxExponent int
+}
+
+// DefaultLimits - рекомендуемые ограничения для формул, которые редактируют пользователи.
+var DefaultLimits = Limits{
+	MaxDepth:     64,
+	MaxRPNLength: 10000,
+	MaxSteps:     100000,
+	MaxDigits:    1000,
+	MaxExponent:  1000,
+}
+
+// checkValue проверяет размер числа, полученного операцией.
+func (lim *Limits) checkValue(val Value, at *Token) error {
+	if lim.MaxDigits > 0 && val.IsNumber() && val.Number().NumDigits() > lim.MaxDigits {
+		return newLimitError(ErrMaxDigits, lim.MaxDigits, at)
+	}
+
+	return nil
+}
+
+// checkPower проверяет показатель степени и оценивает размер результата до вычисления.
+func (lim *Limits) checkPower(base, exponent decimal.Decimal, at *Token) error {
+	if lim.MaxExponent > 0 && exponent.Abs().GreaterThan(decimal.NewFromInt(int64(lim.MaxExponent))) {
+		return newLimitError(ErrMaxExponent, lim.MaxExponent, at)
+	}
+
+	if lim.MaxDigits > 0 && !base.IsZero() {
+		log := math.Log10(math.Abs(base.InexactFloat64()))
+
+		// отрицательная оценка означает малый по модулю результат, его размер ограничен точностью деления
+		digits := exponent.InexactFloat64() * log
+
+		// целая часть степени вычисляется точно, в том числе перед делением для отрицательного показателя:
+		// коэффициент base^n содержит около |n| * log10(коэффициента base) цифр, 1.0001^2000000 - около 8 млн
+		exact := math.Abs(exponent.Truncate(0).InexactFloat64()) * (log - float64(base.Exponent()))
+
+		if digits > float64(lim.MaxDigits) || exact > float64(lim.MaxDigits) {
+			return newLimitError(ErrMaxDigits, lim.MaxDigits, at)
+		}
+	}
+
+	return nil
+}
+
+// checkFactorial оценивает число цифр n! через логарифм гамма-функции до вычисления.
+func (lim *Limits) checkFactorial(n decimal.Decimal, at *Token) error {
+	if lim.MaxDigits <= 0 || n.IsNegative() {
+		return nil
+	}
+
+	lgamma, _ := math.Lgamma(n.InexactFloat64() + 1)
+	if lgamma/math.Ln10 > float64(lim.MaxDigits) {
+		return newLimitError(ErrMaxDigits, lim.MaxDigits, at)
+	}
+
+	return nil
+}
+
+// step учитывает шаг вычисления - выполнение одной инструкции - и проверяет MaxSteps.
+func (st *evalState) step(at *Token) error {
+	st.steps++
+	if st.limits.MaxSteps > 0 && st.steps > st.limits.MaxSteps {
+		return newLimitError(ErrMaxSteps, st.limits.MaxSteps, at)
+	}
+
+	return nil
+}
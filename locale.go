@@ -0,0 +1,54 @@
+package decexpr
+
+import (
+	pkgErrors "github.com/pkg/errors"
+)
+
+// Locale задает разделители в записи чисел и аргументов функций.
+type Locale struct {
+	// DecimalSeparator - разделитель целой и дробной части: '.' или ','.
+	DecimalSeparator byte
+	// GroupSeparator - разделитель групп разрядов (' ', '.', ',', '\''); 0 - группы не разделяются.
+	// Группа после разделителя состоит ровно из трех цифр: "1 234 567,5".
+	GroupSeparator byte
+	// ArgumentSeparator - разделитель аргументов функций и элементов списков: ',' или ';'.
+	// При ';' инструкции сценария разделяются только переводом строки.
+	ArgumentSeparator byte
+}
+
+// DefaultLocale - запись чисел по умолчанию: "1234.56", аргументы через запятую.
+var DefaultLocale = Locale{DecimalSeparator: '.', ArgumentSeparator: ','}
+
+// EuropeanLocale - континентальная запись в стиле Excel: "1 234,56", аргументы через точку с запятой.
+var EuropeanLocale = Locale{DecimalSeparator: ',', GroupSeparator: ' ', ArgumentSeparator: ';'}
+
+// WithLexerLocale задает разделители чисел и аргументов.
+func WithLexerLocale(locale Locale) LexerOption {
+	return func(l *Lexer) {
+		l.locale = locale
+	}
+}
+
+func (loc Locale) validate() error {
+	if loc.DecimalSeparator != '.' && loc.DecimalSeparator != ',' {
+		return pkgErrors.Errorf("invalid decimal separator %q", loc.DecimalSeparator)
+	}
+
+	if loc.ArgumentSeparator != ',' && loc.ArgumentSeparator != ';' {
+		return pkgErrors.Errorf("invalid argument separator %q", loc.ArgumentSeparator)
+	}
+
+	switch loc.GroupSeparator {
+	case 0, ' ', '.', ',', '\'':
+	default:
+		return pkgErrors.Errorf("invalid group separator %q", loc.GroupSeparator)
+	}
+
+	if loc.DecimalSeparator == loc.ArgumentSeparator || loc.DecimalSeparator == loc.GroupSeparator ||
+		loc.GroupSeparator == loc.ArgumentSeparator {
+		return pkgErrors.Errorf("locale separators must differ: decimal %q, group %q, argument %q",
+			loc.DecimalSeparator, loc.GroupSeparator, loc.ArgumentSeparator)
+	}
+
+	return nil
+}
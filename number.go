@@ -0,0 +1,167 @@
+package decexpr
+
+import (
+	"math"
+	"math/bits"
+
+	"github.com/shopspring/decimal"
+)
+
+// Быстрые операции над числами с коэффициентом до 15 цифр. decimal выделяет память на каждое выравнивание
+// показателей и на каждый шаг деления; здесь коэффициент результата считается в int64 и память выделяется
+// только под результат. Коэффициент и показатель результата совпадают с результатом decimal.
+
+// pow10 - степени 10, помещающиеся в uint64.
+var pow10 = [...]uint64{
+	1, 1e1, 1e2, 1e3, 1e4, 1e5, 1e6, 1e7, 1e8, 1e9,
+	1e10, 1e11, 1e12, 1e13, 1e14, 1e15, 1e16, 1e17, 1e18, 1e19,
+}
+
+// smallCoefficient возвращает коэффициент числа, если в нем не больше 15 цифр.
+func smallCoefficient(d decimal.Decimal) (int64, bool) {
+	// NumDigits не выделяет память для коэффициентов до 2^53
+	if d.NumDigits() > 15 {
+		return 0, false
+	}
+
+	return d.CoefficientInt64(), true
+}
+
+// alignSmall приводит коэффициенты к меньшему показателю, как decimal.RescalePair.
+// Приведенные коэффициенты меньше 2^62, поэтому их сумма и разность не выходят за int64.
+func alignSmall(a, b decimal.Decimal) (x, y int64, exp int32, ok bool) {
+	x, okA := smallCoefficient(a)
+	y, okB := smallCoefficient(b)
+
+	diff := int64(a.Exponent()) - int64(b.Exponent())
+	if !okA || !okB || diff > 18 || diff < -18 {
+		return 0, 0, 0, false
+	}
+
+	if diff > 0 {
+		x, ok = scaleSmall(x, diff)
+
+		return x, y, b.Exponent(), ok
+	}
+
+	y, ok = scaleSmall(y, -diff)
+
+	return x, y, a.Exponent(), ok
+}
+
+// scaleSmall умножает коэффициент на 10^n, если результат меньше 2^62 по модулю.
+func scaleSmall(x int64, n int64) (int64, bool) {
+	hi, lo := bits.Mul64(absInt64(x), pow10[n])
+	if hi != 0 || lo >= 1<<62 {
+		return 0, false
+	}
+
+	return withSign(lo, x, 1), true
+}
+
+func addNumbers(a, b decimal.Decimal) decimal.Decimal {
+	if x, y, exp, ok := alignSmall(a, b); ok {
+		return decimal.New(x+y, exp)
+	}
+
+	return a.Add(b)
+}
+
+func subNumbers(a, b decimal.Decimal) decimal.Decimal {
+	if x, y, exp, ok := alignSmall(a, b); ok {
+		return decimal.New(x-y, exp)
+	}
+
+	return a.Sub(b)
+}
+
+func mulNumbers(a, b decimal.Decimal) decimal.Decimal {
+	x, okA := smallCoefficient(a)
+	y, okB := smallCoefficient(b)
+
+	exp := int64(a.Exponent()) + int64(b.Exponent())
+	if okA && okB && exp >= math.MinInt32 && exp <= math.MaxInt32 {
+		hi, lo := bits.Mul64(absInt64(x), absInt64(y))
+		if hi == 0 && lo <= math.MaxInt64 {
+			return decimal.New(withSign(lo, x, y), int32(exp))
+		}
+	}
+
+	return a.Mul(b)
+}
+
+// divNumbers повторяет decimal.Div: частное с DivisionPrecision знаками после точки,
+// цифра 5 округляется от нуля. Делимое a * 10^e занимает до 128 бит.
+func divNumbers(a, b decimal.Decimal) decimal.Decimal {
+	prec := int64(decimal.DivisionPrecision)
+
+	x, okA := smallCoefficient(a)
+	y, okB := smallCoefficient(b)
+
+	e := int64(a.Exponent()) - int64(b.Exponent()) + prec
+	if !okA || !okB || y == 0 || e > 19 || e < -19 {
+		return a.Div(b)
+	}
+
+	hi, lo, divisor := uint64(0), absInt64(x), absInt64(y)
+
+	if e >= 0 {
+		hi, lo = bits.Mul64(lo, pow10[e])
+	} else {
+		var over uint64
+
+		over, divisor = bits.Mul64(divisor, pow10[-e])
+		if over != 0 {
+			return a.Div(b)
+		}
+	}
+
+	if hi >= divisor {
+		return a.Div(b)
+	}
+
+	q, r := bits.Div64(hi, lo, divisor)
+	if q >= math.MaxInt64 {
+		return a.Div(b)
+	}
+
+	if r >= divisor-r {
+		q++
+	}
+
+	return decimal.New(withSign(q, x, y), -int32(prec))
+}
+
+// cmpNumbers сравнивает числа без выравнивания показателей в big.Int.
+func cmpNumbers(a, b decimal.Decimal) int {
+	x, y, _, ok := alignSmall(a, b)
+	if !ok {
+		return a.Cmp(b)
+	}
+
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func absInt64(x int64) uint64 {
+	if x < 0 {
+		return uint64(-x)
+	}
+
+	return uint64(x)
+}
+
+// withSign возвращает модуль результата со знаком произведения x * y.
+func withSign(abs uint64, x, y int64) int64 {
+	if (x < 0) != (y < 0) {
+		return -int64(abs)
+	}
+
+	return int64(abs)
+}
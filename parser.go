@@ -1,45 +1,309 @@
 package decexpr
 
 import (
+	"maps"
+	"slices"
+	"strings"
+
 	pkgErrors "github.com/pkg/errors"
 )
 
 type Parser struct {
-	functions map[string]int
+	functions   map[string]int
+	lexerOpts   []LexerOption
+	implicitMul bool
+	aliases     map[string]string
+	limits      Limits
+}
+
+type ParserOption func(p *Parser)
+
+// WithLexerOptions задает настройки лексера, с которыми разбираются выражения.
+func WithLexerOptions(opts ...LexerOption) ParserOption {
+	return func(p *Parser) {
+		p.lexerOpts = append(p.lexerOpts, opts...)
+	}
+}
+
+// WithImplicitMultiplication вставляет умножение между числом или скобкой и следующим операндом:
+// "2x + 3(y - 1)" разбирается как "2*x + 3*(y - 1)".
+func WithImplicitMultiplication() ParserOption {
+	return func(p *Parser) {
+		p.implicitMul = true
+	}
+}
+
+// WithParserAliases задает дополнительные имена функций: {"сумма": "sum"}.
+// Псевдоним заменяется на имя функции при разборе.
+func WithParserAliases(aliases map[string]string) ParserOption {
+	return func(p *Parser) {
+		if p.aliases == nil {
+			p.aliases = make(map[string]string, len(aliases))
+		}
+
+		maps.Copy(p.aliases, aliases)
+	}
+}
+
+// WithParserLimits задает ограничения вложенности, размера разобранного выражения и литералов.
+func WithParserLimits(limits Limits) ParserOption {
+	return func(p *Parser) {
+		p.limits = limits
+	}
 }
 
-func NewParser(functions map[string]int) *Parser {
-	return &Parser{
+func NewParser(functions map[string]int, opts ...ParserOption) *Parser {
+	p := &Parser{
 		functions: functions,
 	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p
+}
+
+// SetFunction регистрирует функцию с заданным числом аргументов (-1 - переменное число).
+func (p *Parser) SetFunction(name string, args int) {
+	p.functions[name] = args
+}
+
+func (p *Parser) RemoveFunction(name string) {
+	delete(p.functions, name)
 }
 
 func (p *Parser) Parse(exp string) ([]*RPNItem, error) {
-	l, err := NewLexer(exp)
+	l, err := NewLexer(exp, p.lexerOpts...)
 	if err != nil {
 		return nil, err
 	}
 
-	output := make([]*RPNItem, 0, len(exp))
-	itemStack := NewStackItems()
-	argsSkack := NewArgStack()
+	tokens := make([]Token, 0, len(exp)/2)
+	depth := 0
 
 	for token := l.NextToken(); token.Type != TokenEOF; token = l.NextToken() {
 		if token.Type == TokenIllegal {
-			return nil, pkgErrors.Errorf("invalid token type: %s, posistion:%d",
-				token.Literal, token.Position)
+			return nil, pkgErrors.Errorf("invalid token type: %s, %s", token.Literal, token.location())
+		}
+
+		if name, ok := p.aliases[token.Literal]; ok && token.Type == TokenFunction {
+			token.Literal = name
+		}
+
+		// перевод строки вне скобок после законченного операнда разделяет инструкции
+		if l.NewlineBefore() && depth == 0 && len(tokens) > 0 &&
+			endsOperand(tokens[len(tokens)-1].Type) && !continuesStatement(token.Type) {
+			tokens = append(tokens, Token{Type: TokenSemicolon, Literal: "\n",
+				Position: token.Position, Line: token.Line, Column: token.Column})
+		}
+
+		if p.implicitMul && len(tokens) > 0 && impliesMultiplication(tokens[len(tokens)-1].Type, token.Type) {
+			tokens = append(tokens, Token{Type: TokenOperator, Literal: OpMul,
+				Position: token.Position, Line: token.Line, Column: token.Column})
+		}
+
+		switch token.Type {
+		case TokenLeftParen, TokenLeftBracket:
+			depth++
+
+			if p.limits.MaxDepth > 0 && depth > p.limits.MaxDepth {
+				return nil, newLimitError(ErrMaxDepth, p.limits.MaxDepth, &token)
+			}
+		case TokenRightParen, TokenRightBracket:
+			depth--
+		}
+
+		tokens = append(tokens, token)
+	}
+
+	output, err := p.parseScript(tokens)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.limits.MaxRPNLength > 0 {
+		// позиция - первый элемент в тексте выражения, на котором превышено ограничение
+		if tokens := rpnTokens(output, nil); len(tokens) > p.limits.MaxRPNLength {
+			slices.SortStableFunc(tokens, func(a, b *Token) int { return a.Position - b.Position })
+
+			return nil, newLimitError(ErrMaxRPNLength, p.limits.MaxRPNLength, tokens[p.limits.MaxRPNLength])
+		}
+	}
+
+	return output, nil
+}
+
+// rpnTokens добавляет к tokens токены элементов выражения вместе с телами лямбд.
+func rpnTokens(items []*RPNItem, tokens []*Token) []*Token {
+	for _, item := range items {
+		tokens = append(tokens, &item.Token)
+		if item.Lambda != nil {
+			tokens = rpnTokens(item.Lambda.Body, tokens)
+		}
+	}
+
+	return tokens
+}
+
+// parseScript разбирает инструкции "let name = expr", разделенные ';' или переводом строки,
+// и завершающее выражение. Каждая инструкция let выводит элемент TokenLet после своего выражения.
+func (p *Parser) parseScript(tokens []Token) ([]*RPNItem, error) {
+	statements := splitStatements(tokens)
+	output := make([]*RPNItem, 0, len(tokens))
+
+	for i, statement := range statements {
+		last := i == len(statements)-1
+		body := statement
+
+		if isLetStatement(statement) {
+			if last {
+				return nil, pkgErrors.Errorf("script must end with an expression, %s",
+					statement[0].location())
+			}
+
+			body = statement[3:]
+			if len(body) == 0 {
+				return nil, pkgErrors.Errorf("empty let statement, %s", statement[0].location())
+			}
+		} else if !last {
+			return nil, pkgErrors.Errorf("expression result is not used, %s", statement[0].location())
+		}
+
+		items, err := p.parseTokens(body)
+		if err != nil {
+			return nil, err
+		}
+
+		output = append(output, items...)
+
+		if len(body) != len(statement) {
+			output = append(output, &RPNItem{
+				Token: Token{Type: TokenLet, Literal: statement[1].Literal, Position: statement[1].Position,
+					Line: statement[1].Line, Column: statement[1].Column},
+			})
+		}
+	}
+
+	return output, nil
+}
+
+// splitStatements делит токены по ';' вне скобок, пропуская пустые инструкции.
+func splitStatements(tokens []Token) [][]Token {
+	statements := make([][]Token, 0, 1)
+	depth := 0
+	begin := 0
+
+	for i, token := range tokens {
+		switch token.Type {
+		case TokenLeftParen, TokenLeftBracket:
+			depth++
+		case TokenRightParen, TokenRightBracket:
+			depth--
+		case TokenSemicolon:
+			if depth == 0 {
+				if i > begin {
+					statements = append(statements, tokens[begin:i])
+				}
+
+				begin = i + 1
+			}
+		}
+	}
+
+	if begin < len(tokens) {
+		statements = append(statements, tokens[begin:])
+	}
+
+	return statements
+}
+
+func isLetStatement(tokens []Token) bool {
+	return len(tokens) >= 3 && tokens[0].Type == TokenIdent && tokens[0].Literal == "let" &&
+		tokens[1].Type == TokenIdent && !strings.Contains(tokens[1].Literal, ".") && tokens[2].Type == TokenAssign
+}
+
+// impliesMultiplication сообщает, подразумевается ли умножение между соседними токенами: "2x", "2(a)", "(a)(b)", "a (b)".
+// Два идентификатора подряд не перемножаются, чтобы не ломать "let x = ...".
+func impliesMultiplication(prev, next TokenType) bool {
+	switch prev {
+	case TokenFloatNumber, TokenPostfixOperator:
+		return next == TokenIdent || next == TokenFunction || next == TokenLeftParen
+	case TokenRightParen:
+		return next == TokenIdent || next == TokenFunction || next == TokenLeftParen || next == TokenFloatNumber
+	case TokenIdent:
+		return next == TokenLeftParen
+	default:
+		return false
+	}
+}
+
+// continuesStatement сообщает, продолжает ли токен после перевода строки предыдущую инструкцию.
+func continuesStatement(tt TokenType) bool {
+	switch tt {
+	case TokenOperator, TokenPostfixOperator, TokenField, TokenLeftBracket, TokenRightParen,
+		TokenRightBracket, TokenComma, TokenArrow, TokenAssign, TokenSemicolon:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *Parser) parseTokens(tokens []Token) ([]*RPNItem, error) {
+	output := make([]*RPNItem, 0, len(tokens))
+	itemStack := NewStackItems()
+	argsSkack := NewArgStack()
+	prevType := TokenEOF
+
+	for i := 0; i < len(tokens); prevType, i = tokens[i].Type, i+1 {
+		token := tokens[i]
+
+		if params, bodyStart, ok := lambdaParams(tokens, i, prevType); ok {
+			lambda, bodyEnd, err := p.parseLambda(tokens, params, bodyStart)
+			if err != nil {
+				return nil, err
+			}
+
+			output = append(output, &RPNItem{
+				Token: Token{Type: TokenLambda, Literal: "->", Position: token.Position,
+					Line: token.Line, Column: token.Column},
+				Lambda: lambda,
+			})
+
+			i = bodyEnd - 1
+
+			continue
+		}
+
+		if token.Type == TokenFunction && token.Literal == lazyFunction {
+			items, end, err := p.parseLazyCall(tokens, i)
+			if err != nil {
+				return nil, err
+			}
+
+			output = append(output, items...)
+			i = end
+
+			continue
 		}
 
 		newItem, err := NewRPNItem(token)
 		if err != nil {
-			return nil, pkgErrors.Wrapf(err, "invalid number token: %s-%s, posistion:%d",
-				token.Literal, token.Type.String(), token.Position)
+			return nil, pkgErrors.Wrapf(err, "invalid number token: %s-%s, %s",
+				token.Literal, token.Type.String(), token.location())
+		}
+
+		if token.Type == TokenFloatNumber && p.limits.MaxDigits > 0 && newItem.Number.NumDigits() > p.limits.MaxDigits {
+			return nil, newLimitError(ErrMaxDigits, p.limits.MaxDigits, &token)
 		}
 
 		switch token.Type {
-		case TokenFloatNumber, TokenIdent:
+		case TokenFloatNumber, TokenIdent, TokenBool, TokenString, TokenNull:
 			output = append(output, newItem)
-		case TokenOperator, TokenUnaryOperator:
+		case TokenUnaryOperator:
+			// префиксный оператор не имеет левого операнда и ничего не выталкивает
+			itemStack.Push(newItem)
+		case TokenOperator:
 			for itemStack.Len() > 0 {
 				item := itemStack.Pop()
 
@@ -61,6 +325,30 @@ func (p *Parser) Parse(exp string) ([]*RPNItem, error) {
 			}
 
 			itemStack.Push(newItem)
+		case TokenPostfixOperator, TokenField:
+			// постфиксный оператор применяется к уже вычисленному операнду,
+			// поэтому сначала выталкиваем закрытые функции, а затем сам оператор сразу уходит в выход
+			for itemStack.Len() > 0 {
+				item := itemStack.Pop()
+
+				if item.Priority < newItem.Priority {
+					itemStack.Push(item)
+
+					break
+				}
+
+				if item.Type == TokenFunction {
+					item.FuncArgCount = argsSkack.Pop()
+
+					if err := p.checkFunction(item); err != nil {
+						return nil, err
+					}
+				}
+
+				output = append(output, item)
+			}
+
+			output = append(output, newItem)
 		case TokenLeftParen:
 			itemStack.Push(newItem)
 		case TokenRightParen:
@@ -71,6 +359,9 @@ func (p *Parser) Parse(exp string) ([]*RPNItem, error) {
 					closed = true
 
 					break
+				} else if item.Type == TokenLeftBracket {
+					return nil, pkgErrors.Errorf("invalid right paren token, unclosed bracket at %s",
+						item.location())
 				} else if item.Type == TokenFunction {
 					item.FuncArgCount = argsSkack.Pop()
 
@@ -83,16 +374,97 @@ func (p *Parser) Parse(exp string) ([]*RPNItem, error) {
 			}
 
 			if !closed {
-				return nil, pkgErrors.Errorf("invalid left paren token: %s-%s, posistion:%d",
-					token.Literal, token.Type.String(), token.Position)
+				return nil, pkgErrors.Errorf("invalid left paren token: %s-%s, %s",
+					token.Literal, token.Type.String(), token.location())
+			}
+
+			if prevType == TokenLeftParen {
+				if top := itemStack.Peek(); top == nil || top.Type != TokenFunction {
+					return nil, pkgErrors.Errorf("empty parentheses, %s", token.location())
+				}
+
+				argsSkack.Empty()
 			}
+		case TokenLeftBracket:
+			// '[' после операнда - индекс, иначе литерал списка;
+			// под маркером '[' лежит элемент, который будет выведен по закрывающей скобке
+			listItem := *newItem
+			listItem.Type = TokenList
+			listItem.Priority = 8
+
+			if endsOperand(prevType) {
+				listItem.Type = TokenIndex
+
+				for itemStack.Len() > 0 {
+					item := itemStack.Pop()
+
+					if item.Priority < listItem.Priority {
+						itemStack.Push(item)
+
+						break
+					}
+
+					if item.Type == TokenFunction {
+						item.FuncArgCount = argsSkack.Pop()
+
+						if err := p.checkFunction(item); err != nil {
+							return nil, err
+						}
+					}
+
+					output = append(output, item)
+				}
+			}
+
+			itemStack.Push(&listItem)
+			itemStack.Push(newItem)
+			argsSkack.Push()
+		case TokenRightBracket:
+			closed := false
+			for itemStack.Len() > 0 {
+				item := itemStack.Pop()
+				if item.Type == TokenLeftBracket {
+					closed = true
+
+					break
+				} else if item.Type == TokenLeftParen {
+					return nil, pkgErrors.Errorf("invalid right bracket token, unclosed paren at %s",
+						item.location())
+				} else if item.Type == TokenFunction {
+					item.FuncArgCount = argsSkack.Pop()
+
+					if err := p.checkFunction(item); err != nil {
+						return nil, err
+					}
+				}
+
+				output = append(output, item)
+			}
+
+			if !closed {
+				return nil, pkgErrors.Errorf("invalid left bracket token: %s-%s, %s",
+					token.Literal, token.Type.String(), token.location())
+			}
+
+			if prevType == TokenLeftBracket {
+				argsSkack.Empty()
+			}
+
+			item := itemStack.Pop()
+			item.FuncArgCount = argsSkack.Pop()
+
+			if item.Type == TokenIndex && item.FuncArgCount != 1 {
+				return nil, pkgErrors.Errorf("index must be a single expression, %s", item.location())
+			}
+
+			output = append(output, item)
 		case TokenFunction:
 			itemStack.Push(newItem)
 			argsSkack.Push()
 		case TokenComma:
 			for itemStack.Len() > 0 {
 				item := itemStack.Pop()
-				if item.Type == TokenLeftParen {
+				if item.Type == TokenLeftParen || item.Type == TokenLeftBracket {
 					itemStack.Push(item)
 
 					break
@@ -109,8 +481,8 @@ func (p *Parser) Parse(exp string) ([]*RPNItem, error) {
 
 			argsSkack.Inc()
 		default:
-			return nil, pkgErrors.Wrapf(err, "invalid number token: %s-%s, posistion:%d",
-				token.Literal, token.Type.String(), l.position)
+			return nil, pkgErrors.Errorf("unexpected token: %s-%s, %s",
+				token.Literal, token.Type.String(), token.location())
 		}
 	}
 
@@ -119,9 +491,11 @@ func (p *Parser) Parse(exp string) ([]*RPNItem, error) {
 
 		switch item.Type {
 		case TokenLeftParen:
-			return nil, pkgErrors.Errorf("invalid left paren token, posistion:%d", item.Position)
+			return nil, pkgErrors.Errorf("invalid left paren token, %s", item.location())
+		case TokenLeftBracket:
+			return nil, pkgErrors.Errorf("invalid left bracket token, %s", item.location())
 		case TokenRightParen:
-			return nil, pkgErrors.Errorf("invalid right paren token, posistion:%d", item.Position)
+			return nil, pkgErrors.Errorf("invalid right paren token, %s", item.location())
 		case TokenFunction:
 			item.FuncArgCount = argsSkack.Pop()
 
@@ -141,15 +515,168 @@ func (p *Parser) Parse(exp string) ([]*RPNItem, error) {
 func (p *Parser) checkFunction(item *RPNItem) error {
 	funcArgs, exists := p.functions[item.Literal]
 	if !exists {
-		return pkgErrors.Errorf("invalid function token: %s, posistion:%d",
-			item.Literal, item.Position)
+		return pkgErrors.Errorf("invalid function token: %s, %s",
+			item.Literal, item.location())
 	}
 
-	if funcArgs > 0 && item.FuncArgCount != funcArgs {
+	if funcArgs >= 0 && item.FuncArgCount != funcArgs {
 		return pkgErrors.Errorf(
-			"function '%s' has %d arguments, expected %d, token position:%d",
-			item.Literal, item.FuncArgCount, funcArgs, item.Position)
+			"function '%s' has %d arguments, expected %d, token %s",
+			item.Literal, item.FuncArgCount, funcArgs, item.location())
 	}
 
 	return nil
 }
+
+// lambdaParams распознает начало лямбды: "x ->" или "(x, y) ->".
+// Возвращает параметры и индекс первого токена тела.
+func lambdaParams(tokens []Token, i int, prevType TokenType) ([]string, int, bool) {
+	switch tokens[i].Type {
+	case TokenIdent:
+		if i+1 < len(tokens) && tokens[i+1].Type == TokenArrow {
+			return []string{tokens[i].Literal}, i + 2, true
+		}
+	case TokenLeftParen:
+		if prevType == TokenFunction {
+			return nil, 0, false
+		}
+
+		params := make([]string, 0, 2)
+
+		for j := i + 1; j < len(tokens); j++ {
+			switch {
+			case tokens[j].Type == TokenRightParen && (len(params) == 0 || tokens[j-1].Type == TokenIdent):
+				if j+1 < len(tokens) && tokens[j+1].Type == TokenArrow {
+					return params, j + 2, true
+				}
+
+				return nil, 0, false
+			case tokens[j].Type == TokenIdent && tokens[j-1].Type != TokenIdent:
+				params = append(params, tokens[j].Literal)
+			case tokens[j].Type == TokenComma && tokens[j-1].Type == TokenIdent:
+			default:
+				return nil, 0, false
+			}
+		}
+	}
+
+	return nil, 0, false
+}
+
+// parseLambda разбирает тело лямбды: оно продолжается до запятой или закрывающей скобки
+// на своем уровне вложенности либо до конца выражения.
+func (p *Parser) parseLambda(tokens []Token, params []string, bodyStart int) (*Lambda, int, error) {
+	for _, param := range params {
+		if strings.Contains(param, ".") {
+			return nil, 0, pkgErrors.Errorf("invalid lambda parameter: %s, %s",
+				param, tokens[bodyStart-1].location())
+		}
+	}
+
+	depth := 0
+	bodyEnd := bodyStart
+
+loop:
+	for ; bodyEnd < len(tokens); bodyEnd++ {
+		switch tokens[bodyEnd].Type {
+		case TokenLeftParen, TokenLeftBracket:
+			depth++
+		case TokenRightParen, TokenRightBracket:
+			if depth == 0 {
+				break loop
+			}
+
+			depth--
+		case TokenComma, TokenSemicolon:
+			if depth == 0 {
+				break loop
+			}
+		}
+	}
+
+	if bodyEnd == bodyStart {
+		return nil, 0, pkgErrors.Errorf("empty lambda body, %s", tokens[bodyStart-1].location())
+	}
+
+	body, err := p.parseTokens(tokens[bodyStart:bodyEnd])
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return &Lambda{Params: params, Body: body}, bodyEnd, nil
+}
+
+// lazyFunction - функция, ветви которой вычисляются лениво.
+const lazyFunction = "if"
+
+// parseLazyCall разбирает вызов if(cond, then, else) целиком: ветви оборачиваются
+// в лямбды без параметров, чтобы вычислялась только выбранная. Возвращает индекс закрывающей скобки.
+func (p *Parser) parseLazyCall(tokens []Token, i int) ([]*RPNItem, int, error) {
+	token := tokens[i]
+	if i+1 >= len(tokens) || tokens[i+1].Type != TokenLeftParen {
+		return nil, 0, pkgErrors.Errorf("invalid function token: %s, %s", token.Literal, token.location())
+	}
+
+	args := make([][]Token, 0, 3)
+	depth := 0
+	begin := i + 2
+
+	for j := begin; j < len(tokens); j++ {
+		switch tokens[j].Type {
+		case TokenLeftParen, TokenLeftBracket:
+			depth++
+		case TokenRightParen, TokenRightBracket:
+			if depth > 0 {
+				depth--
+
+				continue
+			}
+
+			if tokens[j].Type == TokenRightBracket {
+				return nil, 0, pkgErrors.Errorf("invalid right bracket token, %s", tokens[j].location())
+			}
+
+			args = append(args, tokens[begin:j])
+
+			item := &RPNItem{Token: token, Priority: 8, FuncArgCount: len(args)}
+			if err := p.checkFunction(item); err != nil {
+				return nil, 0, err
+			}
+
+			output := make([]*RPNItem, 0, j-i)
+
+			for k, arg := range args {
+				if len(arg) == 0 {
+					return nil, 0, pkgErrors.Errorf("empty argument %d of function '%s', %s",
+						k+1, token.Literal, token.location())
+				}
+
+				body, err := p.parseTokens(arg)
+				if err != nil {
+					return nil, 0, err
+				}
+
+				if k == 0 {
+					output = append(output, body...)
+
+					continue
+				}
+
+				output = append(output, &RPNItem{
+					Token: Token{Type: TokenLambda, Literal: "->", Position: arg[0].Position,
+						Line: arg[0].Line, Column: arg[0].Column},
+					Lambda: &Lambda{Body: body},
+				})
+			}
+
+			return append(output, item), j, nil
+		case TokenComma:
+			if depth == 0 {
+				args = append(args, tokens[begin:j])
+				begin = j + 1
+			}
+		}
+	}
+
+	return nil, 0, pkgErrors.Errorf("invalid left paren token, %s", tokens[i+1].location())
+}
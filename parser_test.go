@@ -18,6 +18,8 @@ var parseFunctions = map[string]int{
 	"sum":  -1,
 	"min":  -1,
 	"max":  -1,
+	"now":  0,
+	"map":  2,
 }
 
 func TestParser(t *testing.T) {
@@ -52,6 +54,42 @@ func TestParser(t *testing.T) {
 			exp:    "1/3",
 			output: "1 3 /",
 		},
+		{
+			exp:    "2^3! + -sum(1, 2)!",
+			output: "2 3 ! ^ 1 2 sum:2 ! -. +",
+		},
+		{
+			exp:    "a + 1 > 2 && !b || c == false",
+			output: "a 1 + 2 > b !. && c false == ||",
+		},
+		{
+			exp:    "now() + sum(now(), 1)",
+			output: "now:0 now:0 1 sum:2 +",
+		},
+		{
+			exp:    "sum([1, 2 + 3], []) + a[max(1, 2)]",
+			output: "1 2 3 + []:2 []:0 sum:2 a 1 2 max:2 [i] +",
+		},
+		{
+			exp:    "map(items, (a, b) -> a + max(b, 1)) + map([], x -> map(x, y -> y.v))",
+			output: "items {a,b: a b 1 max:2 +} map:2 []:0 {x: x {y: y.v} map:2} map:2 +",
+		},
+		{
+			exp:    "2^-1",
+			output: "2 1 -. ^",
+		},
+		{
+			exp:    "-x ?? 2 + a ?? b * c",
+			output: "x 2 ?? -. a b ?? c * +",
+		},
+		{
+			exp:    "2 ^ x ?? 3 - -y ?? 1",
+			output: "2 x 3 ?? ^ y 1 ?? -. -",
+		},
+		{
+			exp:    "let a = 1 + b; let c = a *\n 2\n a - c",
+			output: "1 b + =a a 2 * =c a c -",
+		},
 	}
 	for _, test := range tests {
 		t.Run(test.exp, func(t *testing.T) {
@@ -71,6 +109,14 @@ func sprintItems(items []*RPNItem) string {
 			str.WriteString(fmt.Sprintf("%s:%d ", item.Token.Literal, item.FuncArgCount))
 		case TokenUnaryOperator:
 			str.WriteString(fmt.Sprintf("%s. ", item.Token.Literal))
+		case TokenList:
+			str.WriteString(fmt.Sprintf("[]:%d ", item.FuncArgCount))
+		case TokenIndex:
+			str.WriteString("[i] ")
+		case TokenLambda:
+			str.WriteString(fmt.Sprintf("{%s: %s} ", strings.Join(item.Lambda.Params, ","), sprintItems(item.Lambda.Body)))
+		case TokenLet:
+			str.WriteString(fmt.Sprintf("=%s ", item.Token.Literal))
 		default:
 			str.WriteString(fmt.Sprintf("%s ", item.Token.Literal))
 
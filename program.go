@@ -0,0 +1,345 @@
+package decexpr
+
+import (
+	"slices"
+	"strings"
+
+	pkgErrors "github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+)
+
+// opcode - код инструкции стековой машины.
+type opcode byte
+
+const (
+	opConst   opcode = iota + 1 // положить consts[arg]
+	opLoad                      // положить значение переменной names[arg]
+	opNeg                       // унарный минус
+	opNot                       // логическое отрицание
+	opPostfix                   // постфиксный оператор: факториал или процент
+	opAdd
+	opSub
+	opMul
+	opDiv
+	opMod
+	opPow
+	opEq
+	opNe
+	opLt
+	opLe
+	opGt
+	opGe
+	opAnd
+	opOr
+	opCoalesce
+	opCall   // вызвать funcs[arg] с n аргументами
+	opField  // поле записи по пути из элемента инструкции
+	opLambda // создать замыкание lambdas[arg]
+	opList   // собрать список из n значений
+	opIndex  // элемент списка по индексу
+	opLet    // сохранить значение в переменную names[arg]
+	opSlot   // положить значение слота n, переменная names[arg] привязана через Bind
+)
+
+var binaryOpcodes = map[Operator]opcode{
+	OpAdd:      opAdd,
+	OpSub:      opSub,
+	OpMul:      opMul,
+	OpDiv:      opDiv,
+	OpMod:      opMod,
+	OpPower:    opPow,
+	OpEq:       opEq,
+	OpNe:       opNe,
+	OpLt:       opLt,
+	OpLe:       opLe,
+	OpGt:       opGt,
+	OpGe:       opGe,
+	OpAnd:      opAnd,
+	OpOr:       opOr,
+	OpCoalesce: opCoalesce,
+}
+
+type instr struct {
+	op  opcode
+	n   int32
+	arg int32
+}
+
+// Program - выражение, скомпилированное в байт-код: инструкции с пулом констант,
+// таблицей переменных и функциями, разрешенными при компиляции.
+type Program struct {
+	eval     *ExpressionEvaluator
+	source   string
+	rpn      []*RPNItem // результат разбора, из которого собрана программа
+	code     []instr
+	items    []*RPNItem // исходный элемент каждой инструкции: позиция и литерал для ошибок
+	consts   []Value
+	names    []string
+	funcs    []FuncInfo
+	lambdas  []*compiledLambda
+	maxStack int
+
+	// slots - имена переменных в порядке слотов после Bind
+	slots []string
+	// retains - программа создает замыкания, которые могут пережить вычисление
+	retains bool
+}
+
+type compiledLambda struct {
+	params []string
+	body   *Program
+}
+
+// Source возвращает текст выражения, из которого собрана программа.
+func (p *Program) Source() string {
+	return p.source
+}
+
+// compile переводит RPN в байт-код и проверяет глубину стека: ошибки вида "2 +" или "1 2"
+// обнаруживаются до вычисления.
+func (e *ExpressionEvaluator) compile(source string, items []*RPNItem) (*Program, error) {
+	p := &Program{
+		eval:   e,
+		source: source,
+		rpn:    items,
+		code:   make([]instr, 0, len(items)),
+		items:  make([]*RPNItem, 0, len(items)),
+	}
+
+	names := make(map[string]int32)
+	depth := 0
+
+	for _, item := range items {
+		in := instr{}
+		pop, push := 0, 1
+
+		switch item.Type {
+		case TokenFloatNumber:
+			in = instr{op: opConst, arg: p.constant(NumberValue(item.Number))}
+		case TokenBool:
+			in = instr{op: opConst, arg: p.constant(BoolValue(item.Literal == "true"))}
+		case TokenString:
+			in = instr{op: opConst, arg: p.constant(StringValue(item.Literal))}
+		case TokenNull:
+			in = instr{op: opConst, arg: p.constant(NullValue())}
+		case TokenIdent:
+			in = instr{op: opLoad, arg: p.name(names, item.Literal)}
+		case TokenUnaryOperator:
+			in, pop = instr{op: opNeg}, 1
+			if item.Literal == OpNot {
+				in.op = opNot
+			}
+
+			if depth < 1 {
+				return nil, pkgErrors.Errorf("invalid unary operator '%s', token %s", item.Literal, item.location())
+			}
+		case TokenPostfixOperator:
+			in, pop = instr{op: opPostfix}, 1
+
+			if depth < 1 {
+				return nil, pkgErrors.Errorf("invalid postfix operator '%s', token %s", item.Literal, item.location())
+			}
+		case TokenOperator:
+			op, ok := binaryOpcodes[item.Literal]
+			if !ok {
+				return nil, pkgErrors.Errorf("unsupported operator '%s', token %s", item.Literal, item.location())
+			}
+
+			in, pop = instr{op: op}, 2
+
+			if depth < 2 {
+				return nil, pkgErrors.Errorf("invalid operator %s, token %s", item.Literal, item.location())
+			}
+		case TokenFunction:
+			function, ok := e.functions[item.Literal]
+			if !ok {
+				return nil, pkgErrors.Errorf("unknown function '%s', token %s", item.Literal, item.location())
+			}
+
+			in, pop = instr{op: opCall, n: int32(item.FuncArgCount), arg: int32(len(p.funcs))}, item.FuncArgCount
+			p.funcs = append(p.funcs, function)
+
+			if depth < item.FuncArgCount {
+				return nil, pkgErrors.Errorf("invalid function %s, token %s", item.Literal, item.location())
+			}
+		case TokenField:
+			in, pop = instr{op: opField}, 1
+
+			if depth < 1 {
+				return nil, pkgErrors.Errorf("invalid field access '%s', token %s", item.Literal, item.location())
+			}
+		case TokenLambda:
+			body, err := e.compile(source, item.Lambda.Body)
+			if err != nil {
+				return nil, err
+			}
+
+			in = instr{op: opLambda, arg: int32(len(p.lambdas))}
+			p.lambdas = append(p.lambdas, &compiledLambda{params: item.Lambda.Params, body: body})
+			p.retains = true
+		case TokenList:
+			in, pop = instr{op: opList, n: int32(item.FuncArgCount)}, item.FuncArgCount
+		case TokenIndex:
+			in, pop = instr{op: opIndex}, 2
+
+			if depth < 2 {
+				return nil, pkgErrors.Errorf("invalid index, token %s", item.location())
+			}
+		case TokenLet:
+			in, pop, push = instr{op: opLet, arg: p.name(names, item.Literal)}, 1, 0
+
+			if depth != 1 {
+				return nil, pkgErrors.Errorf("invalid let %s, token %s", item.Literal, item.location())
+			}
+		default:
+			return nil, pkgErrors.Errorf("unknown token '%s', token %s", item.Literal, item.location())
+		}
+
+		depth += push - pop
+		p.maxStack = max(p.maxStack, depth)
+
+		if p.fold(in, item, &e.limits) {
+			continue
+		}
+
+		p.code = append(p.code, in)
+		p.items = append(p.items, item)
+	}
+
+	if depth > 1 {
+		return nil, pkgErrors.New("stack values is not empty")
+	}
+
+	return p, nil
+}
+
+// fold вычисляет при компиляции операцию над числовыми константами: "-5" и "20 * 30.5" становятся константами.
+// Операции, которые могут завершиться ошибкой, остаются на время вычисления.
+func (p *Program) fold(in instr, item *RPNItem, limits *Limits) bool {
+	operands := 2
+	if in.op == opNeg {
+		operands = 1
+	}
+
+	if in.op != opNeg && (in.op < opAdd || in.op > opCoalesce) || len(p.code) < operands {
+		return false
+	}
+
+	vals := make([]Value, operands)
+
+	for i, prev := range p.code[len(p.code)-operands:] {
+		if prev.op != opConst || !p.consts[prev.arg].IsNumber() {
+			return false
+		}
+
+		vals[i] = p.consts[prev.arg]
+	}
+
+	var value Value
+
+	if in.op == opNeg {
+		value = NumberValue(vals[0].Number().Neg())
+	} else {
+		var ok bool
+		if value, ok = numberOperator(in.op, vals[0], vals[1]); !ok {
+			return false
+		}
+	}
+
+	if limits.checkValue(value, &item.Token) != nil {
+		return false
+	}
+
+	p.code = p.code[:len(p.code)-operands]
+	p.items = p.items[:len(p.items)-operands]
+	p.code = append(p.code, instr{op: opConst, arg: p.constant(value)})
+	p.items = append(p.items, item)
+
+	return true
+}
+
+func (p *Program) constant(v Value) int32 {
+	p.consts = append(p.consts, v)
+
+	return int32(len(p.consts) - 1)
+}
+
+func (p *Program) name(names map[string]int32, name string) int32 {
+	if i, ok := names[name]; ok {
+		return i
+	}
+
+	p.names = append(p.names, name)
+	names[name] = int32(len(p.names) - 1)
+
+	return names[name]
+}
+
+// Variables возвращает имена переменных, которые программа берет из переданных значений,
+// включая переменные тел лямбд и вызываемых пользовательских функций.
+func (p *Program) Variables() []string {
+	var vars []string
+
+	p.variables(nil, make(map[*Program]bool), &vars)
+
+	return vars
+}
+
+func (p *Program) variables(scope []string, visited map[*Program]bool, vars *[]string) {
+	scope = slices.Clip(scope)
+
+	for _, in := range p.code {
+		switch in.op {
+		case opLoad, opSlot:
+			name := p.names[in.arg]
+			base, _, _ := strings.Cut(name, ".")
+
+			if !slices.Contains(scope, name) && !slices.Contains(scope, base) && !slices.Contains(*vars, name) {
+				*vars = append(*vars, name)
+			}
+		case opLet:
+			scope = append(scope, p.names[in.arg])
+		case opLambda:
+			lambda := p.lambdas[in.arg]
+			lambda.body.variables(append(slices.Clip(scope), lambda.params...), visited, vars)
+		case opCall:
+			// тело пользовательской функции видит только свои параметры и переменные вызова
+			if fn := p.funcs[in.arg].user; fn != nil && fn.body != nil && !visited[fn.body] {
+				visited[fn.body] = true
+				fn.body.variables(fn.params, visited, vars)
+			}
+		}
+	}
+}
+
+// Eval вычисляет программу с числовыми переменными.
+func (p *Program) Eval(identValue map[string]decimal.Decimal, opts ...EvalOption) (decimal.Decimal, error) {
+	res, err := p.run(decimalIdents(identValue), opts)
+	if err != nil {
+		return decimal.Decimal{}, err
+	}
+
+	if !res.IsNumber() {
+		return decimal.Decimal{}, pkgErrors.Errorf("invalid expression: %s: result is %s, expected number", p.source, res.Kind())
+	}
+
+	return res.Number(), nil
+}
+
+// EvalValue вычисляет программу с типизированными переменными.
+func (p *Program) EvalValue(identValue map[string]Value, opts ...EvalOption) (Value, error) {
+	return p.run(valueIdents(identValue), opts)
+}
+
+func (p *Program) run(idents identResolver, opts []EvalOption) (Value, error) {
+	st := p.eval.newState(idents, opts)
+
+	res, err := p.eval.exec(p, idents, st)
+	releaseState(st)
+
+	if err != nil {
+		return Value{}, pkgErrors.Wrapf(err, "invalid expression: %s", p.source)
+	}
+
+	return res, nil
+}
@@ -0,0 +1,298 @@
+package decexpr
+
+import (
+	"slices"
+	"sync"
+
+	pkgErrors "github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+)
+
+// batchBlock - число строк, которое обрабатывается за один проход по инструкциям.
+// Ограничивает память под столбцы стека при больших пакетах.
+const batchBlock = 1024
+
+// BatchOption - настройка пакетного вычисления EvalBatch.
+type BatchOption func(o *batchOptions)
+
+type batchOptions struct {
+	workers int
+}
+
+// WithBatchWorkers делит строки пакета между n горутинами.
+func WithBatchWorkers(n int) BatchOption {
+	return func(o *batchOptions) {
+		o.workers = n
+	}
+}
+
+// EvalBatch вычисляет программу для каждой строки столбцов: columns["qty"][i] - значение qty в строке i.
+// Каждая инструкция выполняется сразу для блока строк. Ошибка строки не прерывает пакет:
+// errs равен nil, если ошибок нет, иначе errs[i] - ошибка строки i. Если столбцы разной длины
+// или для переменной нет столбца, res равен nil, а ошибка пакета записана в errs для каждой строки;
+// число строк в этом случае - длина самого длинного столбца, для пустого пакета errs состоит из одной ошибки.
+func (p *Program) EvalBatch(columns map[string][]decimal.Decimal, opts ...BatchOption) ([]decimal.Decimal, []error) {
+	var o batchOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	names := make([]string, 0, len(columns))
+	rows := 0
+
+	var err error
+
+	for name, col := range columns {
+		if len(names) > 0 && len(col) != rows && err == nil {
+			err = pkgErrors.Errorf("invalid expression: %s: column %s has %d rows, expected %d",
+				p.source, name, len(col), rows)
+		}
+
+		names = append(names, name)
+		rows = max(rows, len(col))
+	}
+
+	bound, bindErr := p.Bind(names)
+	if err == nil && bindErr != nil {
+		err = pkgErrors.Wrapf(bindErr, "invalid expression: %s", p.source)
+	}
+
+	if err != nil {
+		errs := make([]error, max(rows, 1))
+		for i := range errs {
+			errs[i] = err
+		}
+
+		return nil, errs
+	}
+
+	cols := make([][]decimal.Decimal, len(names))
+	for i, name := range names {
+		cols[i] = columns[name]
+	}
+
+	res := make([]decimal.Decimal, rows)
+	errs := make([]error, rows)
+
+	workers := min(max(o.workers, 1), max(rows/batchBlock, 1))
+	chunk := (rows + workers - 1) / max(workers, 1)
+
+	var wg sync.WaitGroup
+
+	for from := 0; from < rows; from += chunk {
+		to := min(from+chunk, rows)
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			bound.evalRows(cols, from, to, res, errs)
+		}()
+	}
+
+	wg.Wait()
+
+	if !slices.ContainsFunc(errs, func(err error) bool { return err != nil }) {
+		return res, nil
+	}
+
+	return res, errs
+}
+
+// evalRows вычисляет строки [from, to) блоками. Программы с let, лямбдами и пользовательскими функциями
+// вычисляются построчно: области видимости у каждой строки свои.
+func (p *Program) evalRows(cols [][]decimal.Decimal, from, to int, res []decimal.Decimal, errs []error) {
+	if len(p.code) == 0 || p.retains || slices.ContainsFunc(p.code, p.scoped) {
+		row := make([]decimal.Decimal, len(cols))
+
+		for i := from; i < to; i++ {
+			for j, col := range cols {
+				row[j] = col[i]
+			}
+
+			res[i], errs[i] = p.EvalSlots(row)
+		}
+
+		return
+	}
+
+	st := p.eval.newState(nil, nil)
+	defer releaseState(st)
+
+	stack := make([][]Value, p.maxStack)
+
+	for i := range stack {
+		stack[i] = make([]Value, min(batchBlock, to-from))
+	}
+
+	for base := from; base < to; base += batchBlock {
+		n := min(batchBlock, to-base)
+
+		p.execBlock(stack, cols, base, n, errs[base:base+n], st)
+
+		for i, value := range stack[0][:n] {
+			switch {
+			case errs[base+i] != nil:
+				errs[base+i] = pkgErrors.Wrapf(errs[base+i], "invalid expression: %s", p.source)
+			case !value.IsNumber():
+				errs[base+i] = pkgErrors.Errorf("invalid expression: %s: result is %s, expected number", p.source, value.Kind())
+			default:
+				res[base+i] = value.Number()
+			}
+		}
+	}
+}
+
+// scoped сообщает, что инструкции нужна область видимости строки: let записывает в нее переменную,
+// а тело пользовательской функции читает из нее глобальные переменные.
+func (p *Program) scoped(in instr) bool {
+	return in.op == opLet || in.op == opCall && p.funcs[in.arg].user != nil
+}
+
+// execBlock выполняет инструкции для n строк, начиная с base. Каждая ячейка стека - столбец значений;
+// строки с ошибкой пропускаются до конца блока.
+func (p *Program) execBlock(stack [][]Value, cols [][]decimal.Decimal, base, n int, errs []error, st *evalState) {
+	top := 0
+
+	// строки блока выполняют одни и те же инструкции, поэтому шаги считаются один раз, как для одной строки
+	st.steps = 0
+
+	for pc := range p.code {
+		in := &p.code[pc]
+		item := p.items[pc]
+
+		if err := st.step(&item.Token); err != nil {
+			for i := range errs {
+				if errs[i] == nil {
+					errs[i] = err
+				}
+			}
+
+			return
+		}
+
+		switch in.op {
+		case opConst:
+			col := stack[top][:n]
+			for i := range col {
+				col[i] = p.consts[in.arg]
+			}
+
+			top++
+		case opSlot:
+			col := stack[top][:n]
+			for i, num := range cols[in.n][base : base+n] {
+				col[i] = NumberValue(num)
+			}
+
+			top++
+		case opNeg, opNot:
+			col := stack[top-1][:n]
+
+			for i := range col {
+				if errs[i] != nil {
+					continue
+				}
+
+				if in.op == opNeg && col[i].kind == KindNumber {
+					col[i].num = col[i].num.Neg()
+
+					continue
+				}
+
+				col[i], errs[i] = evalUnaryOperator(item, col[i], st)
+			}
+		case opPostfix:
+			col := stack[top-1][:n]
+
+			for i := range col {
+				if errs[i] == nil {
+					col[i], errs[i] = evalPostfixOperator(item, col[i], st)
+				}
+
+				if errs[i] == nil {
+					errs[i] = st.limits.checkValue(col[i], &item.Token)
+				}
+			}
+		case opAdd, opSub, opMul, opDiv, opMod, opPow, opEq, opNe, opLt, opLe, opGt, opGe, opAnd, opOr, opCoalesce:
+			col1, col2 := stack[top-2][:n], stack[top-1][:n]
+
+			for i := range col1 {
+				if errs[i] != nil {
+					continue
+				}
+
+				value, ok := numberOperator(in.op, col1[i], col2[i])
+				if !ok {
+					value, errs[i] = evalOperator(item, col1[i], col2[i], st)
+				}
+
+				if errs[i] == nil {
+					errs[i] = st.limits.checkValue(value, &item.Token)
+				}
+
+				col1[i] = value
+			}
+
+			top--
+		case opCall:
+			function := &p.funcs[in.arg]
+			args := make([]Value, in.n)
+			first := top - int(in.n)
+
+			for i := range n {
+				if errs[i] != nil {
+					continue
+				}
+
+				for j := range args {
+					args[j] = stack[first+j][i]
+				}
+
+				value, err := p.eval.callFunction(function, args, st)
+				if err != nil {
+					errs[i] = pkgErrors.Wrapf(err, "invalid function '%s', token %s", item.Literal, item.location())
+
+					continue
+				}
+
+				errs[i] = st.limits.checkValue(value, &item.Token)
+				stack[first][i] = value
+			}
+
+			top = first + 1
+		case opField:
+			col := stack[top-1][:n]
+
+			for i := range col {
+				if errs[i] == nil {
+					col[i], errs[i] = evalField(item, col[i], st)
+				}
+			}
+		case opList:
+			first := top - int(in.n)
+
+			for i := range n {
+				vals := make([]Value, in.n)
+				for j := range vals {
+					vals[j] = stack[first+j][i]
+				}
+
+				stack[first][i] = ListValue(vals...)
+			}
+
+			top = first + 1
+		case opIndex:
+			col1, col2 := stack[top-2][:n], stack[top-1][:n]
+
+			for i := range col1 {
+				if errs[i] == nil {
+					col1[i], errs[i] = evalIndex(item, col1[i], col2[i], st)
+				}
+			}
+
+			top--
+		}
+	}
+}
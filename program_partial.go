@@ -0,0 +1,166 @@
+package decexpr
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/shopspring/decimal"
+)
+
+// Partial подставляет известные значения переменных, вычисляет константные подвыражения и возвращает
+// программу, в которой остались только неизвестные: "price * (1 + rate)" с rate = 0.2 становится "price * 1.2".
+// Source результата - остаток выражения. Встроенные функции с константными аргументами вычисляются,
+// кроме now() и today(); функции, добавленные через AddFunc, и тела пользовательских функций не затрагиваются.
+func (p *Program) Partial(known map[string]decimal.Decimal) (*Program, error) {
+	if len(p.rpn) == 0 {
+		return p, nil
+	}
+
+	stmts, err := buildTree(p.rpn)
+	if err != nil {
+		return nil, err
+	}
+
+	var shadow []string
+
+	for _, n := range stmts {
+		if n.item.Type != TokenLet {
+			continue
+		}
+
+		n.args[0] = p.eval.partial(n.args[0], known, shadow)
+
+		// переменная let скрывает одноименную известную переменную в следующих операторах
+		shadow = append(shadow, n.item.Literal)
+	}
+
+	last := len(stmts) - 1
+	stmts[last] = p.eval.partial(stmts[last], known, shadow)
+
+	return p.eval.Compile(formatTree(stmts, p.eval.locale()))
+}
+
+func (e *ExpressionEvaluator) partial(n *node, known map[string]decimal.Decimal, shadow []string) *node {
+	switch n.item.Type {
+	case TokenIdent:
+		base, _, _ := strings.Cut(n.item.Literal, ".")
+		if slices.Contains(shadow, n.item.Literal) || slices.Contains(shadow, base) {
+			return n
+		}
+
+		if v, ok := known[n.item.Literal]; ok {
+			c, _ := constNode(NumberValue(v), n.item.Position)
+
+			return c
+		}
+
+		return n
+	case TokenLambda:
+		n.args[0] = e.partial(n.args[0], known, append(slices.Clip(shadow), n.params...))
+
+		return n
+	}
+
+	for i, arg := range n.args {
+		n.args[i] = e.partial(arg, known, shadow)
+	}
+
+	// if с известным условием заменяется выбранной ветвью
+	if n.item.Type == TokenFunction && n.item.Literal == lazyFunction && len(n.args) == 3 && n.args[0].item.Type == TokenBool {
+		branch := n.args[2]
+		if n.args[0].item.Literal == "true" {
+			branch = n.args[1]
+		}
+
+		if branch.item.Type == TokenLambda && len(branch.params) == 0 {
+			return branch.args[0]
+		}
+
+		return branch
+	}
+
+	if !e.foldable(n) {
+		return n
+	}
+
+	// ошибка, например деление на 0, остается до вычисления
+	value, err := e.evalNode(n)
+	if err != nil {
+		return n
+	}
+
+	if c, ok := constNode(value, n.item.Position); ok {
+		return c
+	}
+
+	return n
+}
+
+// foldable сообщает, можно ли вычислить узел при компиляции: все операнды - литералы, а операция не зависит
+// от времени, контекста и функций вызывающего кода.
+func (e *ExpressionEvaluator) foldable(n *node) bool {
+	switch n.item.Type {
+	case TokenOperator, TokenUnaryOperator, TokenPostfixOperator:
+	case TokenFunction:
+		if fi, ok := e.builtin(n.item.Literal); !ok || fi.clockCall != nil {
+			return false
+		}
+	default:
+		return false
+	}
+
+	for _, arg := range n.args {
+		if !arg.isConst() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// builtin возвращает функцию вычислителя, если под именем зарегистрирована встроенная функция, а не функция
+// вызывающего кода с тем же именем. Таблица functions не меняется: вычислители, в том числе Default, получают ее копию.
+func (e *ExpressionEvaluator) builtin(name string) (FuncInfo, bool) {
+	e.lock.RLock()
+	fi, ok := e.functions[name]
+	e.lock.RUnlock()
+
+	std, isStd := functions[name]
+	if !ok || !isStd || fi.user != nil || fi.ContextCall != nil {
+		return FuncInfo{}, false
+	}
+
+	same := reflect.ValueOf(fi.Call).Pointer() == reflect.ValueOf(std.Call).Pointer() &&
+		reflect.ValueOf(fi.ValueCall).Pointer() == reflect.ValueOf(std.ValueCall).Pointer()
+
+	return fi, same
+}
+
+// evalNode вычисляет константное поддерево.
+func (e *ExpressionEvaluator) evalNode(n *node) (Value, error) {
+	e.lock.RLock()
+	p, err := e.compile("", flatten([]*node{n}))
+	e.lock.RUnlock()
+
+	if err != nil {
+		return Value{}, err
+	}
+
+	idents := valueIdents(nil)
+
+	st := e.newState(idents, nil)
+	defer releaseState(st)
+
+	return e.exec(p, idents, st)
+}
+
+// locale возвращает формат чисел и разделитель аргументов, с которыми вычислитель разбирает выражения.
+func (e *ExpressionEvaluator) locale() Locale {
+	l, err := NewLexer("", e.parser.lexerOpts...)
+	if err != nil {
+		return DefaultLocale
+	}
+
+	return l.locale
+}
@@ -0,0 +1,79 @@
+package decexpr
+
+import (
+	"slices"
+
+	pkgErrors "github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+)
+
+// Bind возвращает копию программы, в которой переменные привязаны к слотам в порядке names.
+// Все свободные переменные программы должны быть перечислены; лишние имена допускаются.
+func (p *Program) Bind(names []string) (*Program, error) {
+	for i, name := range names {
+		if slices.Contains(names[:i], name) {
+			return nil, pkgErrors.Errorf("duplicate slot %s", name)
+		}
+	}
+
+	bound := *p
+	bound.code = slices.Clone(p.code)
+	bound.slots = slices.Clone(names)
+
+	// переменные let определяются самой программой и остаются в области видимости
+	lets := make(map[int32]bool)
+
+	for pc := range bound.code {
+		in := &bound.code[pc]
+
+		switch in.op {
+		case opLet:
+			lets[in.arg] = true
+		case opLoad, opSlot:
+			if lets[in.arg] {
+				continue
+			}
+
+			slot := slices.Index(names, p.names[in.arg])
+			if slot < 0 {
+				return nil, pkgErrors.Errorf("variable %s is not bound, token %s",
+					p.names[in.arg], p.items[pc].location())
+			}
+
+			in.op, in.n = opSlot, int32(slot)
+		}
+	}
+
+	return &bound, nil
+}
+
+// Slots возвращает имена переменных в порядке слотов, заданном Bind.
+func (p *Program) Slots() []string {
+	return slices.Clone(p.slots)
+}
+
+// EvalSlots вычисляет программу, привязанную через Bind, со значениями переменных по слотам.
+// Переменные читаются по индексу без поиска в map; кроме арифметики decimal вычисление не выделяет память.
+func (p *Program) EvalSlots(vals []decimal.Decimal, opts ...EvalOption) (decimal.Decimal, error) {
+	if len(vals) != len(p.slots) {
+		return decimal.Decimal{}, pkgErrors.Errorf("invalid expression: %s: expected %d slot values, got %d",
+			p.source, len(p.slots), len(vals))
+	}
+
+	st := statePool.Get().(*evalState)
+	p.eval.initState(st, &st.slots, opts)
+	st.slots = slotIdents{names: p.slots, vals: vals}
+
+	res, err := p.eval.exec(p, st.globals, st)
+	releaseState(st)
+
+	if err != nil {
+		return decimal.Decimal{}, pkgErrors.Wrapf(err, "invalid expression: %s", p.source)
+	}
+
+	if !res.IsNumber() {
+		return decimal.Decimal{}, pkgErrors.Errorf("invalid expression: %s: result is %s, expected number", p.source, res.Kind())
+	}
+
+	return res.Number(), nil
+}
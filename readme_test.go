@@ -0,0 +1,111 @@
+package decexpr
+
+import (
+	"maps"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestReadme выполняет примеры из README: строка примера должна дословно встречаться в README,
+// выражение - первый строковый литерал в ней.
+func TestReadme(t *testing.T) {
+	readme, err := os.ReadFile("README.md")
+	assert.NoError(t, err)
+
+	num := func(s string) Value {
+		return NumberValue(decimal.RequireFromString(s))
+	}
+
+	tests := []struct {
+		snippet string
+		defs    []string
+		vars    map[string]Value
+		opts    []EvalOption
+		result  string
+	}{
+		{
+			snippet: `expr := "sum(1, 3+5, min(5*10, 7-val1)) + val2 * 6.67 + (10 % 3)"`,
+			vars:    map[string]Value{"val1": num("10"), "val2": num("3")},
+			result:  "27.01",
+		},
+		{
+			snippet: `total, err := decexpr.EvalValue("sum(line_totals) * (1 - discount ?? 0)", vars)`,
+			vars:    map[string]Value{"line_totals": ListValue(num("10"), num("20.5")), "discount": NullValue()},
+			result:  "30.5",
+		},
+		{
+			snippet: `decexpr.EvalValue("price * (1 - discount ?? 0)", vars, decexpr.WithMissing(decexpr.MissingNull))`,
+			vars:    map[string]Value{"price": num("100")},
+			opts:    []EvalOption{WithMissing(MissingNull)},
+			result:  "100",
+		},
+		{
+			snippet: `result, _ := decexpr.Eval("net(200) + fact(5)", map[string]decimal.Decimal{"discount": decimal.RequireFromString("0.1")})`,
+			defs:    []string{"def net(x) = x * (1 - discount)", "def fact(n) = if(n <= 1, 1, n * fact(n - 1))"},
+			vars:    map[string]Value{"discount": num("0.1")},
+			result:  "300",
+		},
+		{
+			snippet: `ok, err := decexpr.EvalBool("qty >= 10 && !blocked", map[string]decexpr.Value{`,
+			vars:    map[string]Value{"qty": num("12"), "blocked": BoolValue(false)},
+			result:  "true",
+		},
+		{
+			snippet: `result, _ := decexpr.Eval("(100 + 20) % 30", nil)`,
+			result:  "0",
+		},
+		{
+			snippet: `result, _ = decexpr.Eval("sum(10 % 3, 20 % 7, min(15, 30 % 4))", nil)`,
+			result:  "9",
+		},
+		{
+			snippet: `p, err := decexpr.Default().Compile("price * qty * (1 - discount)")`,
+			vars:    map[string]Value{"price": num("10"), "qty": num("3"), "discount": num("0.1")},
+			result:  "27",
+		},
+	}
+
+	literal := regexp.MustCompile(`"((?:[^"\\]|\\.)*)"`)
+
+	for _, test := range tests {
+		t.Run(test.snippet, func(t *testing.T) {
+			assert.True(t, strings.Contains(string(readme), test.snippet), "snippet is not in README")
+
+			eval := NewExpressionEvaluator(false, maps.Clone(functions))
+			for _, def := range test.defs {
+				assert.NoError(t, eval.Define(def))
+			}
+
+			exp := literal.FindStringSubmatch(test.snippet)[1]
+
+			v, err := eval.EvalValue(exp, test.vars, test.opts...)
+			assert.NoError(t, err)
+			assert.Equal(t, test.result, v.String())
+		})
+	}
+
+	for _, snippet := range []string{
+		`d, err := decexpr.Derivative("price * (1 + rate) ^ years", "rate")`,
+		`d, _ = decexpr.Derivative("3 * rate ^ 2 - rate / 4", "rate")`,
+	} {
+		t.Run(snippet, func(t *testing.T) {
+			i := strings.Index(string(readme), snippet)
+			assert.GreaterOrEqual(t, i, 0, "snippet is not in README")
+
+			// ожидаемый результат - комментарий в следующей строке примера
+			next, _, _ := strings.Cut(string(readme[i+len(snippet)+1:]), "\n")
+			_, want, _ := strings.Cut(next, "// ")
+
+			m := literal.FindAllStringSubmatch(snippet, 2)
+
+			d, err := Derivative(m[0][1], m[1][1])
+			assert.NoError(t, err)
+			assert.Equal(t, want, d)
+		})
+	}
+}
@@ -0,0 +1,246 @@
+package decexpr
+
+import (
+	"maps"
+	"slices"
+	"strings"
+
+	pkgErrors "github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+)
+
+// Sheet - набор именованных формул, которые ссылаются друг на друга, как ячейки таблицы:
+// gross = qty * price, tax = gross * rate, total = gross + tax.
+// Формулы вычисляются в порядке зависимостей, каждая один раз. Sheet не предназначен для одновременного использования.
+type Sheet struct {
+	eval  *ExpressionEvaluator
+	cells map[string]*sheetCell
+	// order - формулы в порядке вычисления: зависимости раньше зависимых
+	order []string
+	// values - переменные последнего вычисления вместе с результатами формул
+	values map[string]decimal.Decimal
+}
+
+type sheetCell struct {
+	name    string
+	program *Program
+	// reads - все переменные формулы, deps - те из них, что являются формулами
+	reads []string
+	deps  []string
+}
+
+// NewSheet разбирает формулы, строит граф зависимостей по идентификаторам формул и упорядочивает его.
+// Идентификаторы, которые не являются формулами, - входные переменные Eval.
+func (e *ExpressionEvaluator) NewSheet(formulas map[string]string) (*Sheet, error) {
+	s := &Sheet{
+		eval:  e,
+		cells: make(map[string]*sheetCell, len(formulas)),
+	}
+
+	names := make([]string, 0, len(formulas))
+	for name := range formulas {
+		names = append(names, name)
+	}
+
+	// порядок обхода не зависит от порядка map, чтобы порядок вычисления и ошибки были воспроизводимы
+	slices.Sort(names)
+
+	for _, name := range names {
+		if !isIdentName(name) {
+			return nil, pkgErrors.Errorf("invalid formula name %q", name)
+		}
+
+		p, err := e.Compile(formulas[name])
+		if err != nil {
+			return nil, pkgErrors.Wrapf(err, "formula %s", name)
+		}
+
+		s.cells[name] = &sheetCell{name: name, program: p}
+	}
+
+	for _, name := range names {
+		cell := s.cells[name]
+		cell.reads = cell.program.Variables()
+
+		for _, v := range cell.reads {
+			// поле записи a.b зависит от a
+			base, _, _ := strings.Cut(v, ".")
+
+			switch {
+			case s.cells[v] != nil && !slices.Contains(cell.deps, v):
+				cell.deps = append(cell.deps, v)
+			case s.cells[base] != nil && !slices.Contains(cell.deps, base):
+				cell.deps = append(cell.deps, base)
+			}
+		}
+
+		slices.Sort(cell.deps)
+	}
+
+	if err := s.sort(names); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// sort упорядочивает формулы обходом в глубину и сообщает цикл путем "a -> b -> a".
+func (s *Sheet) sort(names []string) error {
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+
+	state := make(map[string]int, len(names))
+	path := make([]string, 0, len(names))
+
+	var visit func(name string) error
+
+	visit = func(name string) error {
+		switch state[name] {
+		case done:
+			return nil
+		case visiting:
+			cycle := append(slices.Clone(path[slices.Index(path, name):]), name)
+
+			return pkgErrors.Errorf("formula cycle: %s", strings.Join(cycle, " -> "))
+		}
+
+		state[name] = visiting
+		path = append(path, name)
+
+		for _, dep := range s.cells[name].deps {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+
+		path = path[:len(path)-1]
+		state[name] = done
+		s.order = append(s.order, name)
+
+		return nil
+	}
+
+	for _, name := range names {
+		if err := visit(name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Order возвращает имена формул в порядке вычисления.
+func (s *Sheet) Order() []string {
+	return slices.Clone(s.order)
+}
+
+// Dependencies возвращает формулы, на которые непосредственно ссылается формула name.
+func (s *Sheet) Dependencies(name string) []string {
+	cell, ok := s.cells[name]
+	if !ok {
+		return nil
+	}
+
+	return slices.Clone(cell.deps)
+}
+
+// Eval вычисляет все формулы с входными переменными vars и возвращает результаты формул по именам.
+// Вычисление останавливается на первой ошибке.
+func (s *Sheet) Eval(vars map[string]decimal.Decimal, opts ...EvalOption) (map[string]decimal.Decimal, error) {
+	values := make(map[string]decimal.Decimal, len(vars)+len(s.order))
+	for name, v := range vars {
+		// входная переменная не может подменить формулу
+		if s.cells[name] == nil {
+			values[name] = v
+		}
+	}
+
+	for _, name := range s.order {
+		res, err := s.cells[name].program.Eval(values, opts...)
+		if err != nil {
+			return nil, pkgErrors.Wrapf(err, "formula %s", name)
+		}
+
+		values[name] = res
+	}
+
+	s.values = values
+
+	return s.results(), nil
+}
+
+// Set меняет входную переменную и пересчитывает только формулы, которые от нее зависят.
+// Возвращает в порядке вычисления формулы, значения которых изменились; зависимые формулы
+// неизменившейся формулы не пересчитываются. При ошибке значения листа остаются прежними.
+func (s *Sheet) Set(name string, v decimal.Decimal, opts ...EvalOption) ([]string, error) {
+	if s.cells[name] != nil {
+		return nil, pkgErrors.Errorf("cannot set formula %s", name)
+	}
+
+	if s.values == nil {
+		return nil, pkgErrors.New("sheet is not evaluated: call Eval before Set")
+	}
+
+	values := maps.Clone(s.values)
+
+	if old, ok := values[name]; ok && old.Equal(v) {
+		return nil, nil
+	}
+
+	values[name] = v
+	dirty := map[string]bool{name: true}
+
+	var changed []string
+
+	for _, formula := range s.order {
+		cell := s.cells[formula]
+		if !slices.ContainsFunc(cell.reads, func(read string) bool {
+			base, _, _ := strings.Cut(read, ".")
+
+			return dirty[read] || dirty[base]
+		}) {
+			continue
+		}
+
+		res, err := cell.program.Eval(values, opts...)
+		if err != nil {
+			return nil, pkgErrors.Wrapf(err, "formula %s", formula)
+		}
+
+		if old, ok := values[formula]; ok && old.Equal(res) {
+			continue
+		}
+
+		values[formula] = res
+		dirty[formula] = true
+		changed = append(changed, formula)
+	}
+
+	s.values = values
+
+	return changed, nil
+}
+
+// Value возвращает значение формулы или входной переменной после последнего Eval или Set.
+func (s *Sheet) Value(name string) (decimal.Decimal, bool) {
+	v, ok := s.values[name]
+
+	return v, ok
+}
+
+// results возвращает значения формул последнего вычисления.
+func (s *Sheet) results() map[string]decimal.Decimal {
+	res := make(map[string]decimal.Decimal, len(s.order))
+	for _, name := range s.order {
+		res[name] = s.values[name]
+	}
+
+	return res
+}
+
+func NewSheet(formulas map[string]string) (*Sheet, error) {
+	return Default().NewSheet(formulas)
+}
@@ -0,0 +1,141 @@
+package decexpr
+
+import (
+	"maps"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSheet(t *testing.T) {
+	eval := NewExpressionEvaluator(true, maps.Clone(functions))
+
+	sheet, err := eval.NewSheet(map[string]string{
+		"total":    "gross + tax",
+		"tax":      "gross * rate",
+		"gross":    "qty * price",
+		"discount": "if(total > 100, total * 0.1, 0)",
+		"lines":    "sum(map([1, 2], x -> x * gross))",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"gross", "tax", "total", "discount", "lines"}, sheet.Order())
+	assert.Equal(t, []string{"gross", "rate"}, sheet.cells["tax"].program.Variables())
+	assert.Equal(t, []string{"gross", "tax"}, sheet.Dependencies("total"))
+	assert.Equal(t, []string{"total"}, sheet.Dependencies("discount"))
+	assert.Equal(t, []string{"gross"}, sheet.Dependencies("lines"))
+
+	_, err = sheet.Eval(map[string]decimal.Decimal{"qty": decimal.NewFromInt(3)})
+	assert.EqualError(t, err, "formula gross: invalid expression: qty * price: ident value not found for price, token position:6, line:1, column:7")
+
+	res, err := sheet.Eval(map[string]decimal.Decimal{
+		"qty":   decimal.NewFromInt(3),
+		"price": decimal.NewFromInt(40),
+		"rate":  decimal.RequireFromString("0.2"),
+		"gross": decimal.NewFromInt(1),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "120", res["gross"].String())
+	assert.Equal(t, "24", res["tax"].String())
+	assert.Equal(t, "144", res["total"].String())
+	assert.Equal(t, "14.4", res["discount"].String())
+	assert.Equal(t, "360", res["lines"].String())
+}
+
+func TestSheet_Errors(t *testing.T) {
+	tests := []struct {
+		formulas map[string]string
+		err      string
+	}{
+		{
+			formulas: map[string]string{"a": "b + 1", "b": "c * 2", "c": "a - x", "d": "1"},
+			err:      "formula cycle: a -> b -> c -> a",
+		},
+		{
+			formulas: map[string]string{"a": "a + 1"},
+			err:      "formula cycle: a -> a",
+		},
+		{
+			formulas: map[string]string{"a": "1", "b": "map([1], x -> x + c)[0]", "c": "b"},
+			err:      "formula cycle: b -> c -> b",
+		},
+		{
+			formulas: map[string]string{"a": "1 +"},
+			err:      "formula a: invalid expression: 1 +: invalid operator +, token position:2, line:1, column:3",
+		},
+		{
+			formulas: map[string]string{"a b": "1"},
+			err:      `invalid formula name "a b"`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.err, func(t *testing.T) {
+			_, err := NewSheet(test.formulas)
+			assert.EqualError(t, err, test.err)
+		})
+	}
+}
+
+func TestSheet_Set(t *testing.T) {
+	eval := NewExpressionEvaluator(true, maps.Clone(functions))
+
+	calls := 0
+	assert.NoError(t, eval.AddFunc("tick", func(vals ...decimal.Decimal) (decimal.Decimal, error) {
+		calls++
+
+		return vals[0], nil
+	}))
+
+	sheet, err := eval.NewSheet(map[string]string{
+		"gross":  "qty * price",
+		"tax":    "gross * rate",
+		"total":  "gross + tax",
+		"unit":   "total / qty",
+		"capped": "min(qty, 10)",
+		"boxes":  "tick(capped) * 2",
+	})
+	assert.NoError(t, err)
+
+	_, err = sheet.Set("qty", decimal.NewFromInt(1))
+	assert.EqualError(t, err, "sheet is not evaluated: call Eval before Set")
+
+	_, err = sheet.Eval(map[string]decimal.Decimal{
+		"qty":   decimal.NewFromInt(12),
+		"price": decimal.NewFromInt(10),
+		"rate":  decimal.RequireFromString("0.2"),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, calls)
+
+	changed, err := sheet.Set("rate", decimal.RequireFromString("0.20"))
+	assert.NoError(t, err)
+	assert.Empty(t, changed)
+
+	changed, err = sheet.Set("rate", decimal.RequireFromString("0.5"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"tax", "total", "unit"}, changed)
+
+	total, ok := sheet.Value("total")
+	assert.True(t, ok)
+	assert.Equal(t, "180", total.String())
+
+	// capped не меняется, поэтому boxes не пересчитывается
+	changed, err = sheet.Set("qty", decimal.NewFromInt(15))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"gross", "tax", "total"}, changed)
+	assert.Equal(t, 1, calls)
+
+	changed, err = sheet.Set("qty", decimal.NewFromInt(2))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"capped", "boxes", "gross", "tax", "total"}, changed)
+	assert.Equal(t, 2, calls)
+
+	_, err = sheet.Set("qty", decimal.Zero)
+	assert.ErrorContains(t, err, "formula unit")
+
+	qty, _ := sheet.Value("qty")
+	assert.Equal(t, "2", qty.String())
+
+	_, err = sheet.Set("total", decimal.Zero)
+	assert.EqualError(t, err, "cannot set formula total")
+}
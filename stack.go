@@ -81,6 +81,15 @@ func (s *ArgStack) Inc() {
 	s.args[len(s.args)-1]++
 }
 
+// Empty отмечает текущий вызов функции как вызов без аргументов.
+func (s *ArgStack) Empty() {
+	if len(s.args) == 0 {
+		return
+	}
+
+	s.args[len(s.args)-1] = 0
+}
+
 func (s *ArgStack) Len() int {
 	return len(s.args)
 }
@@ -129,3 +138,48 @@ func (s *NumberStack) PopN(n int) ([]decimal.Decimal, error) {
 func (s *NumberStack) Len() int {
 	return len(s.numbers)
 }
+
+type ValueStack struct {
+	values []Value
+}
+
+func NewValueStack(size int) *ValueStack {
+	return &ValueStack{
+		values: make([]Value, 0, size),
+	}
+}
+
+func (s *ValueStack) Push(value Value) {
+	s.values = append(s.values, value)
+}
+
+func (s *ValueStack) Pop() Value {
+	if len(s.values) == 0 {
+		return Value{}
+	}
+
+	v := s.values[len(s.values)-1]
+
+	s.values = s.values[:len(s.values)-1]
+
+	return v
+}
+
+func (s *ValueStack) PopN(n int) ([]Value, error) {
+	if len(s.values) < n {
+		return nil, pkgErrors.New("Value stack is too short")
+	}
+
+	res := make([]Value, 0, n)
+	for i := len(s.values) - n; i < len(s.values); i++ {
+		res = append(res, s.values[i])
+	}
+
+	s.values = s.values[:len(s.values)-n]
+
+	return res, nil
+}
+
+func (s *ValueStack) Len() int {
+	return len(s.values)
+}
@@ -1,5 +1,7 @@
 package decexpr
 
+import "fmt"
+
 type TokenType byte
 
 const (
@@ -14,6 +16,23 @@ const (
 	TokenLeftParen
 	TokenRightParen
 	TokenComma
+	// новые типы добавляются в конец, чтобы значения существующих не менялись
+	TokenPostfixOperator
+	TokenBool
+	TokenString
+	TokenNull
+	TokenLeftBracket
+	TokenRightBracket
+	TokenArrow
+	TokenField
+	TokenAssign
+	TokenSemicolon
+
+	// типы элементов RPN, которые не порождаются лексером
+	TokenList
+	TokenIndex
+	TokenLambda
+	TokenLet
 )
 
 func (tt TokenType) String() string {
@@ -26,8 +45,16 @@ func (tt TokenType) String() string {
 		return "IntNumber"
 	case TokenFloatNumber:
 		return "FloatNumber"
+	case TokenBool:
+		return "Bool"
+	case TokenString:
+		return "String"
+	case TokenNull:
+		return "Null"
 	case TokenUnaryOperator:
 		return "UnaryOperator"
+	case TokenPostfixOperator:
+		return "PostfixOperator"
 	case TokenOperator:
 		return "Operator"
 	case TokenIdent:
@@ -38,24 +65,62 @@ func (tt TokenType) String() string {
 		return "LeftParen"
 	case TokenRightParen:
 		return "RightParen"
+	case TokenLeftBracket:
+		return "LeftBracket"
+	case TokenRightBracket:
+		return "RightBracket"
 	case TokenComma:
 		return "Comma"
+	case TokenArrow:
+		return "Arrow"
+	case TokenField:
+		return "Field"
+	case TokenAssign:
+		return "Assign"
+	case TokenSemicolon:
+		return "Semicolon"
+	case TokenList:
+		return "List"
+	case TokenIndex:
+		return "Index"
+	case TokenLambda:
+		return "Lambda"
+	case TokenLet:
+		return "Let"
 	default:
 		return "Unknown"
 	}
 }
 
+// endsOperand сообщает, завершает ли токен данного типа операнд.
+func endsOperand(tt TokenType) bool {
+	switch tt {
+	case TokenFloatNumber, TokenIntNumber, TokenIdent, TokenBool, TokenString, TokenNull,
+		TokenRightParen, TokenRightBracket, TokenPostfixOperator, TokenField:
+		return true
+	default:
+		return false
+	}
+}
+
 type Token struct {
 	Type     TokenType
-	Exp      int16
-	Position int16
+	Exp      int
+	Position int // смещение в байтах от начала выражения
+	Line     int // номер строки, начиная с 1
+	Column   int // номер символа в строке, начиная с 1
 	Literal  string
 }
 
+// location - позиция токена в сообщениях об ошибках: смещение в байтах, строка и столбец.
+func (t *Token) location() string {
+	return fmt.Sprintf("position:%d, line:%d, column:%d", t.Position, t.Line, t.Column)
+}
+
 func newToken(tokenType TokenType, literal string, position int) Token {
 	return Token{
 		Type:     tokenType,
 		Literal:  literal,
-		Position: int16(position),
+		Position: position,
 	}
 }
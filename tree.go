@@ -0,0 +1,307 @@
+package decexpr
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+
+	pkgErrors "github.com/pkg/errors"
+)
+
+// node - узел дерева выражения, построенного из RPN: элемент RPN и его операнды.
+// У лямбды params - параметры, args[0] - тело; у let args[0] - значение переменной.
+type node struct {
+	item   *RPNItem
+	args   []*node
+	params []string
+}
+
+// buildTree восстанавливает по RPN дерево. Результат - операторы сценария:
+// узлы let и итоговое выражение последним элементом.
+func buildTree(items []*RPNItem) ([]*node, error) {
+	var (
+		stack []*node
+		stmts []*node
+	)
+
+	for _, item := range items {
+		n := &node{item: item}
+		operands := 0
+
+		switch item.Type {
+		case TokenUnaryOperator, TokenPostfixOperator, TokenField, TokenLet:
+			operands = 1
+		case TokenOperator, TokenIndex:
+			operands = 2
+		case TokenFunction, TokenList:
+			operands = item.FuncArgCount
+		case TokenLambda:
+			body, err := buildTree(item.Lambda.Body)
+			if err != nil {
+				return nil, err
+			}
+
+			if len(body) != 1 {
+				return nil, pkgErrors.Errorf("invalid lambda body, token %s", item.location())
+			}
+
+			n.params = item.Lambda.Params
+			n.args = body
+		}
+
+		if len(stack) < operands {
+			return nil, pkgErrors.Errorf("invalid token '%s', token %s", item.Literal, item.location())
+		}
+
+		if operands > 0 {
+			n.args = append([]*node(nil), stack[len(stack)-operands:]...)
+			stack = stack[:len(stack)-operands]
+		}
+
+		if item.Type == TokenLet {
+			stmts = append(stmts, n)
+
+			continue
+		}
+
+		stack = append(stack, n)
+	}
+
+	if len(stack) != 1 {
+		return nil, pkgErrors.New("stack values is not empty")
+	}
+
+	return append(stmts, stack[0]), nil
+}
+
+// flatten переводит дерево обратно в RPN.
+func flatten(stmts []*node) []*RPNItem {
+	var items []*RPNItem
+
+	var walk func(n *node)
+
+	walk = func(n *node) {
+		if n.item.Type == TokenLambda {
+			item := *n.item
+			item.Lambda = &Lambda{Params: n.params, Body: flatten(n.args)}
+			items = append(items, &item)
+
+			return
+		}
+
+		for _, arg := range n.args {
+			walk(arg)
+		}
+
+		items = append(items, n.item)
+	}
+
+	for _, n := range stmts {
+		walk(n)
+	}
+
+	return items
+}
+
+// isConst сообщает, является ли узел литералом.
+func (n *node) isConst() bool {
+	switch n.item.Type {
+	case TokenFloatNumber, TokenBool, TokenString, TokenNull:
+		return true
+	default:
+		return false
+	}
+}
+
+// constNode создает литерал для значения; списки, даты и другие значения без литерала не поддерживаются.
+func constNode(v Value, position int) (*node, bool) {
+	item := &RPNItem{Token: Token{Position: position}}
+
+	switch {
+	case v.IsNumber():
+		item.Type, item.Number, item.Literal = TokenFloatNumber, v.Number(), v.Number().String()
+	case v.IsBool():
+		item.Type, item.Literal = TokenBool, strconv.FormatBool(v.Bool())
+	case v.IsString():
+		item.Type, item.Literal = TokenString, v.String()
+	case v.IsNull():
+		item.Type, item.Literal = TokenNull, "null"
+	default:
+		return nil, false
+	}
+
+	return &node{item: item}, true
+}
+
+// priority - приоритет узла при расстановке скобок; атомы, вызовы и постфиксные операции связаны сильнее всего.
+func (n *node) priority() int {
+	switch n.item.Type {
+	case TokenOperator:
+		return n.item.Priority
+	case TokenUnaryOperator:
+		return 7
+	case TokenFloatNumber:
+		if n.item.Number.IsNegative() {
+			return 7
+		}
+	case TokenLambda:
+		return 0
+	}
+
+	return 9
+}
+
+// formatTree печатает операторы сценария в синтаксисе выражений с минимумом скобок.
+func formatTree(stmts []*node, locale Locale) string {
+	var b strings.Builder
+
+	for _, n := range stmts {
+		if n.item.Type == TokenLet {
+			b.WriteString("let ")
+			b.WriteString(n.item.Literal)
+			b.WriteString(" = ")
+			n.args[0].format(&b, locale)
+			b.WriteString("; ")
+
+			continue
+		}
+
+		n.format(&b, locale)
+	}
+
+	return b.String()
+}
+
+func (n *node) format(b *strings.Builder, locale Locale) {
+	item := n.item
+
+	switch item.Type {
+	case TokenFloatNumber:
+		num := item.Number.String()
+		if locale.DecimalSeparator != '.' {
+			num = strings.Replace(num, ".", string(locale.DecimalSeparator), 1)
+		}
+
+		b.WriteString(num)
+	case TokenBool, TokenIdent:
+		b.WriteString(item.Literal)
+	case TokenNull:
+		b.WriteString("null")
+	case TokenString:
+		b.WriteString(quote(item.Literal))
+	case TokenUnaryOperator:
+		b.WriteString(item.Literal)
+		n.args[0].formatOperand(b, locale, n.args[0].priority() < 9)
+	case TokenPostfixOperator:
+		n.args[0].formatOperand(b, locale, n.args[0].priority() < 9)
+		b.WriteString(item.Literal)
+	case TokenOperator:
+		left, right := n.args[0], n.args[1]
+
+		// операторы левоассоциативны: правый операнд того же приоритета берется в скобки
+		left.formatOperand(b, locale, left.priority() < item.Priority)
+		b.WriteString(" ")
+		b.WriteString(item.Literal)
+		b.WriteString(" ")
+		right.formatOperand(b, locale, right.priority() <= item.Priority)
+	case TokenFunction:
+		b.WriteString(item.Literal)
+		b.WriteString("(")
+
+		for i, arg := range n.args {
+			if i > 0 {
+				b.WriteByte(locale.ArgumentSeparator)
+				b.WriteString(" ")
+			}
+
+			// ветви if разобраны как лямбды без параметров
+			if item.Literal == lazyFunction && arg.item.Type == TokenLambda && len(arg.params) == 0 {
+				arg = arg.args[0]
+			}
+
+			arg.format(b, locale)
+		}
+
+		b.WriteString(")")
+	case TokenList:
+		b.WriteString("[")
+
+		for i, arg := range n.args {
+			if i > 0 {
+				b.WriteByte(locale.ArgumentSeparator)
+				b.WriteString(" ")
+			}
+
+			arg.format(b, locale)
+		}
+
+		b.WriteString("]")
+	case TokenIndex:
+		n.args[0].formatOperand(b, locale, n.args[0].priority() < 9)
+		b.WriteString("[")
+		n.args[1].format(b, locale)
+		b.WriteString("]")
+	case TokenField:
+		n.args[0].formatOperand(b, locale, n.args[0].priority() < 9)
+		b.WriteString(".")
+		b.WriteString(item.Literal)
+	case TokenLambda:
+		if len(n.params) == 1 {
+			b.WriteString(n.params[0])
+		} else {
+			b.WriteString("(")
+			b.WriteString(strings.Join(n.params, string(locale.ArgumentSeparator)+" "))
+			b.WriteString(")")
+		}
+
+		b.WriteString(" -> ")
+		n.args[0].format(b, locale)
+	}
+}
+
+func (n *node) formatOperand(b *strings.Builder, locale Locale, parens bool) {
+	if parens {
+		b.WriteString("(")
+	}
+
+	n.format(b, locale)
+
+	if parens {
+		b.WriteString(")")
+	}
+}
+
+// quote записывает строку литералом в двойных кавычках с экранированием, которое понимает лексер.
+func quote(s string) string {
+	var b strings.Builder
+
+	b.WriteByte('"')
+
+	for _, r := range s {
+		switch r {
+		case '"', '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\r':
+			b.WriteString(`\r`)
+		default:
+			if r <= 0xFFFF && !unicode.IsPrint(r) {
+				b.WriteString(`\u`)
+				b.WriteString(strings.Repeat("0", 4-len(strconv.FormatInt(int64(r), 16))))
+				b.WriteString(strconv.FormatInt(int64(r), 16))
+
+				continue
+			}
+
+			b.WriteRune(r)
+		}
+	}
+
+	b.WriteByte('"')
+
+	return b.String()
+}
@@ -0,0 +1,412 @@
+package decexpr
+
+import (
+	"context"
+	"maps"
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	pkgErrors "github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+)
+
+type ValueKind byte
+
+const (
+	KindNumber ValueKind = iota + 1
+	KindBool
+	KindString
+	KindDate
+	KindDateTime
+	KindDuration
+	KindNull
+	KindList
+	KindRecord
+	KindFunction
+)
+
+func (k ValueKind) String() string {
+	switch k {
+	case KindNumber:
+		return "number"
+	case KindBool:
+		return "bool"
+	case KindString:
+		return "string"
+	case KindDate:
+		return "date"
+	case KindDateTime:
+		return "datetime"
+	case KindDuration:
+		return "duration"
+	case KindNull:
+		return "null"
+	case KindList:
+		return "list"
+	case KindRecord:
+		return "record"
+	case KindFunction:
+		return "function"
+	default:
+		return "invalid"
+	}
+}
+
+// Value - значение на стеке вычислителя: число, логическое значение, строка, дата, длительность,
+// список, запись или функция. Длительность хранится в num как точное количество секунд.
+// Числа и логические значения хранятся в самой структуре, остальные значения - в ext:
+// стек вычислителя копирует Value на каждой операции, поэтому структура должна быть маленькой.
+type Value struct {
+	kind ValueKind
+	b    bool
+	num  decimal.Decimal
+	ext  *valueExt
+}
+
+type valueExt struct {
+	str    string
+	t      time.Time
+	list   []Value
+	fields map[string]Value
+	fn     Callable
+}
+
+// zeroExt читается у значений без ext.
+var zeroExt valueExt
+
+func (v *Value) x() *valueExt {
+	if v.ext == nil {
+		return &zeroExt
+	}
+
+	return v.ext
+}
+
+// Callable - значение-функция, например лямбда "x -> x * 2", переданная в map или filter.
+type Callable interface {
+	Call(args ...Value) (Value, error)
+}
+
+func NumberValue(num decimal.Decimal) Value {
+	return Value{kind: KindNumber, num: num}
+}
+
+func BoolValue(b bool) Value {
+	return Value{kind: KindBool, b: b}
+}
+
+func StringValue(str string) Value {
+	return Value{kind: KindString, ext: &valueExt{str: str}}
+}
+
+// DateValue отбрасывает время суток: дата хранится как полночь UTC.
+func DateValue(t time.Time) Value {
+	y, m, d := t.Date()
+
+	return Value{kind: KindDate, ext: &valueExt{t: time.Date(y, m, d, 0, 0, 0, 0, time.UTC)}}
+}
+
+func DateTimeValue(t time.Time) Value {
+	return Value{kind: KindDateTime, ext: &valueExt{t: t}}
+}
+
+func DurationValue(d time.Duration) Value {
+	return Value{kind: KindDuration, num: decimal.New(d.Nanoseconds(), -9)}
+}
+
+// maxDurationSeconds - наибольшая длительность time.Duration в секундах.
+var maxDurationSeconds = decimal.New(math.MaxInt64, -9)
+
+func toDuration(seconds decimal.Decimal) (time.Duration, error) {
+	if seconds.Abs().GreaterThan(maxDurationSeconds) {
+		return 0, pkgErrors.Errorf("duration %ss is out of range, maximum is %ss", seconds, maxDurationSeconds)
+	}
+
+	return time.Duration(seconds.Shift(9).IntPart()), nil
+}
+
+func DurationSecondsValue(seconds decimal.Decimal) Value {
+	return Value{kind: KindDuration, num: seconds}
+}
+
+func NullValue() Value {
+	return Value{kind: KindNull}
+}
+
+func ListValue(vals ...Value) Value {
+	return Value{kind: KindList, ext: &valueExt{list: vals}}
+}
+
+func DecimalListValue(nums []decimal.Decimal) Value {
+	vals := make([]Value, 0, len(nums))
+	for _, num := range nums {
+		vals = append(vals, NumberValue(num))
+	}
+
+	return ListValue(vals...)
+}
+
+func RecordValue(fields map[string]Value) Value {
+	return Value{kind: KindRecord, ext: &valueExt{fields: fields}}
+}
+
+func FunctionValue(fn Callable) Value {
+	return Value{kind: KindFunction, ext: &valueExt{fn: fn}}
+}
+
+// ValueOf преобразует значение Go в Value. Поддерживаются decimal.Decimal, []decimal.Decimal,
+// bool, string, time.Time (как дата со временем), time.Duration, []Value, map[string]Value,
+// []map[string]Value, Value и nil.
+func ValueOf(v any) (Value, error) {
+	switch v := v.(type) {
+	case nil:
+		return NullValue(), nil
+	case Value:
+		return v, nil
+	case decimal.Decimal:
+		return NumberValue(v), nil
+	case []decimal.Decimal:
+		return DecimalListValue(v), nil
+	case bool:
+		return BoolValue(v), nil
+	case string:
+		return StringValue(v), nil
+	case time.Time:
+		return DateTimeValue(v), nil
+	case time.Duration:
+		return DurationValue(v), nil
+	case []Value:
+		return ListValue(v...), nil
+	case map[string]Value:
+		return RecordValue(v), nil
+	case []map[string]Value:
+		vals := make([]Value, 0, len(v))
+		for _, fields := range v {
+			vals = append(vals, RecordValue(fields))
+		}
+
+		return ListValue(vals...), nil
+	default:
+		return Value{}, pkgErrors.Errorf("unsupported value type %T", v)
+	}
+}
+
+func (v Value) Kind() ValueKind {
+	return v.kind
+}
+
+func (v Value) IsNumber() bool {
+	return v.kind == KindNumber
+}
+
+func (v Value) IsBool() bool {
+	return v.kind == KindBool
+}
+
+func (v Value) IsString() bool {
+	return v.kind == KindString
+}
+
+func (v Value) IsNull() bool {
+	return v.kind == KindNull
+}
+
+func (v Value) IsList() bool {
+	return v.kind == KindList
+}
+
+func (v Value) IsRecord() bool {
+	return v.kind == KindRecord
+}
+
+func (v Value) IsFunction() bool {
+	return v.kind == KindFunction
+}
+
+func (v Value) IsTime() bool {
+	return v.kind == KindDate || v.kind == KindDateTime
+}
+
+func (v Value) IsDuration() bool {
+	return v.kind == KindDuration
+}
+
+func (v Value) Number() decimal.Decimal {
+	return v.num
+}
+
+func (v Value) Bool() bool {
+	return v.b
+}
+
+func (v Value) Str() string {
+	return v.x().str
+}
+
+func (v Value) Time() time.Time {
+	return v.x().t
+}
+
+// Duration возвращает ошибку, если длительность не помещается в time.Duration (около 292 лет).
+func (v Value) Duration() (time.Duration, error) {
+	return toDuration(v.num)
+}
+
+func (v Value) Seconds() decimal.Decimal {
+	return v.num
+}
+
+func (v Value) List() []Value {
+	return v.x().list
+}
+
+func (v Value) Field(name string) (Value, bool) {
+	field, ok := v.x().fields[name]
+
+	return field, ok
+}
+
+func (v Value) Fields() map[string]Value {
+	return v.x().fields
+}
+
+// Call вызывает значение-функцию.
+func (v Value) Call(args ...Value) (Value, error) {
+	if v.kind != KindFunction {
+		return Value{}, pkgErrors.Errorf("%s is not callable", v.kind)
+	}
+
+	return v.x().fn.Call(args...)
+}
+
+// contextCallable - значение-функция, принимающее контекст вызывающего, например лямбда.
+type contextCallable interface {
+	CallContext(ctx context.Context, args ...Value) (Value, error)
+}
+
+// CallContext вызывает значение-функцию с контекстом: лямбда, вернувшаяся из вычисления, проверяет его отмену.
+// Функции без контекста вызываются через Call.
+func (v Value) CallContext(ctx context.Context, args ...Value) (Value, error) {
+	if fn, ok := v.x().fn.(contextCallable); ok && v.kind == KindFunction {
+		return fn.CallContext(ctx, args...)
+	}
+
+	return v.Call(args...)
+}
+
+func (v Value) Equal(other Value) bool {
+	if v.kind != other.kind {
+		return false
+	}
+
+	x, y := v.x(), other.x()
+
+	switch v.kind {
+	case KindNumber, KindDuration:
+		return v.num.Equal(other.num)
+	case KindDate, KindDateTime:
+		return x.t.Equal(y.t)
+	case KindBool:
+		return v.b == other.b
+	case KindString:
+		return x.str == y.str
+	case KindList:
+		return slices.EqualFunc(x.list, y.list, Value.Equal)
+	case KindRecord:
+		return maps.EqualFunc(x.fields, y.fields, Value.Equal)
+	case KindFunction:
+		return x.fn == y.fn
+	default:
+		return true
+	}
+}
+
+func (v Value) String() string {
+	x := v.x()
+
+	switch v.kind {
+	case KindNumber:
+		return v.num.String()
+	case KindBool:
+		if v.b {
+			return "true"
+		}
+
+		return "false"
+	case KindString:
+		return x.str
+	case KindDate:
+		return x.t.Format(time.DateOnly)
+	case KindDateTime:
+		return x.t.Format(time.RFC3339Nano)
+	case KindDuration:
+		d, err := v.Duration()
+		if err != nil {
+			return v.num.String() + "s"
+		}
+
+		return d.String()
+	case KindNull:
+		return "null"
+	case KindList:
+		items := make([]string, 0, len(x.list))
+		for _, item := range x.list {
+			items = append(items, item.String())
+		}
+
+		return "[" + strings.Join(items, ", ") + "]"
+	case KindRecord:
+		items := make([]string, 0, len(x.fields))
+		for _, name := range slices.Sorted(maps.Keys(x.fields)) {
+			items = append(items, name+": "+x.fields[name].String())
+		}
+
+		return "{" + strings.Join(items, ", ") + "}"
+	case KindFunction:
+		return "<function>"
+	default:
+		return "<invalid>"
+	}
+}
+
+// identResolver возвращает значения идентификаторов при вычислении выражения.
+type identResolver interface {
+	lookup(name string) (Value, bool)
+}
+
+type decimalIdents map[string]decimal.Decimal
+
+func (m decimalIdents) lookup(name string) (Value, bool) {
+	v, ok := m[name]
+	if !ok {
+		return Value{}, false
+	}
+
+	return NumberValue(v), true
+}
+
+type valueIdents map[string]Value
+
+func (m valueIdents) lookup(name string) (Value, bool) {
+	v, ok := m[name]
+
+	return v, ok
+}
+
+// slotIdents - значения переменных по слотам Program.Bind.
+type slotIdents struct {
+	names []string
+	vals  []decimal.Decimal
+}
+
+func (s *slotIdents) lookup(name string) (Value, bool) {
+	for i, slot := range s.names {
+		if slot == name {
+			return NumberValue(s.vals[i]), true
+		}
+	}
+
+	return Value{}, false
+}
@@ -0,0 +1,249 @@
+package decexpr
+
+import (
+	"slices"
+	"sync"
+
+	pkgErrors "github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+)
+
+// stackPool - стеки значений, переиспользуемые между вычислениями.
+var stackPool = sync.Pool{
+	New: func() any {
+		stack := make([]Value, 16)
+
+		return &stack
+	},
+}
+
+// exec выполняет программу на стеке из пула.
+func (e *ExpressionEvaluator) exec(p *Program, idents identResolver, st *evalState) (Value, error) {
+	sp := stackPool.Get().(*[]Value)
+	if len(*sp) < p.maxStack {
+		*sp = make([]Value, p.maxStack)
+	}
+
+	res, err := e.execStack(p, *sp, idents, st)
+
+	// значения на стеке не должны удерживаться пулом; инструкции не обнуляют освободившиеся ячейки,
+	// стек очищается один раз после вычисления
+	clear((*sp)[:p.maxStack])
+	stackPool.Put(sp)
+
+	return res, err
+}
+
+func (e *ExpressionEvaluator) execStack(p *Program, stack []Value, idents identResolver, st *evalState) (Value, error) {
+	top := 0
+
+	for pc := range p.code {
+		in := &p.code[pc]
+
+		if st.done != nil {
+			select {
+			case <-st.done:
+				return Value{}, pkgErrors.Wrapf(st.ctx.Err(), "evaluation stopped, token %s", p.items[pc].location())
+			default:
+			}
+		}
+
+		if err := st.step(&p.items[pc].Token); err != nil {
+			return Value{}, err
+		}
+
+		switch in.op {
+		case opConst:
+			stack[top] = p.consts[in.arg]
+			top++
+		case opSlot:
+			if st.slots.vals != nil {
+				stack[top] = NumberValue(st.slots.vals[in.n])
+				top++
+
+				break
+			}
+
+			fallthrough
+		case opLoad:
+			value, ok := lookupIdent(idents, p.names[in.arg])
+			if !ok {
+				switch st.missing {
+				case MissingNull:
+					value = NullValue()
+				case MissingDefault:
+					value = st.missingDefault
+				default:
+					return Value{}, pkgErrors.Errorf(
+						"ident value not found for %s, token %s",
+						p.names[in.arg], p.items[pc].location())
+				}
+			}
+
+			stack[top] = value
+			top++
+		case opNeg, opNot:
+			val := &stack[top-1]
+			if in.op == opNeg && val.kind == KindNumber {
+				val.num = val.num.Neg()
+
+				continue
+			}
+
+			value, err := evalUnaryOperator(p.items[pc], *val, st)
+			if err != nil {
+				return Value{}, err
+			}
+
+			*val = value
+		case opPostfix:
+			value, err := evalPostfixOperator(p.items[pc], stack[top-1], st)
+			if err != nil {
+				return Value{}, err
+			}
+
+			if err := st.limits.checkValue(value, &p.items[pc].Token); err != nil {
+				return Value{}, err
+			}
+
+			stack[top-1] = value
+		case opAdd, opSub, opMul, opDiv, opMod, opPow, opEq, opNe, opLt, opLe, opGt, opGe, opAnd, opOr, opCoalesce:
+			val1, val2 := stack[top-2], stack[top-1]
+
+			value, ok := numberOperator(in.op, val1, val2)
+			if !ok {
+				var err error
+
+				value, err = evalOperator(p.items[pc], val1, val2, st)
+				if err != nil {
+					return Value{}, err
+				}
+			}
+
+			if err := st.limits.checkValue(value, &p.items[pc].Token); err != nil {
+				return Value{}, err
+			}
+
+			top--
+			stack[top-1] = value
+		case opCall:
+			function := &p.funcs[in.arg]
+			args := stack[top-int(in.n) : top]
+
+			// тело пользовательской функции может создать замыкание над аргументами, стек для этого не годится
+			if function.user != nil {
+				args = slices.Clone(args)
+			}
+
+			v, err := e.callFunction(function, args, st)
+			if err != nil {
+				item := p.items[pc]
+
+				if function.user != nil && st.callDepth > 0 {
+					// ошибки вложенных вызовов пользовательских функций оборачиваются один раз, на внешнем уровне
+					return Value{}, err
+				}
+
+				return Value{}, pkgErrors.Wrapf(err, "invalid function '%s', token %s",
+					item.Literal, item.location())
+			}
+
+			if err := st.limits.checkValue(v, &p.items[pc].Token); err != nil {
+				return Value{}, err
+			}
+
+			top -= int(in.n)
+			stack[top] = v
+			top++
+		case opField:
+			value, err := evalField(p.items[pc], stack[top-1], st)
+			if err != nil {
+				return Value{}, err
+			}
+
+			stack[top-1] = value
+		case opLambda:
+			if st.env == nil {
+				st.env = &closureEnv{st: st}
+			}
+
+			stack[top] = FunctionValue(&closure{eval: e, lambda: p.lambdas[in.arg], idents: idents, env: st.env})
+			top++
+		case opList:
+			vals := slices.Clone(stack[top-int(in.n) : top])
+
+			top -= int(in.n)
+			stack[top] = ListValue(vals...)
+			top++
+		case opIndex:
+			value, err := evalIndex(p.items[pc], stack[top-2], stack[top-1], st)
+			if err != nil {
+				return Value{}, err
+			}
+
+			top--
+			stack[top-1] = value
+		case opLet:
+			top--
+			value := stack[top]
+
+			// следующие инструкции видят переменную, лямбды сохраняют область видимости момента создания
+			name := p.names[in.arg]
+			idents = &scopeIdents{names: []string{name}, values: []Value{value}, parent: idents}
+
+			if st.lets != nil && st.callDepth == 0 {
+				st.lets[name] = value
+			}
+		}
+	}
+
+	if top == 0 {
+		return NumberValue(decimal.Decimal{}), nil
+	}
+
+	return stack[0], nil
+}
+
+// numberOperator вычисляет оператор над двумя числами без разбора литерала оператора.
+// Деление и остаток на ноль, степень и операции над другими типами выполняет evalOperator.
+func numberOperator(op opcode, val1, val2 Value) (Value, bool) {
+	if val1.kind != KindNumber || val2.kind != KindNumber {
+		return Value{}, false
+	}
+
+	switch op {
+	case opAdd:
+		return NumberValue(addNumbers(val1.num, val2.num)), true
+	case opSub:
+		return NumberValue(subNumbers(val1.num, val2.num)), true
+	case opMul:
+		return NumberValue(mulNumbers(val1.num, val2.num)), true
+	case opDiv, opMod:
+		// ошибку деления на ноль возвращает evalOperator
+		if val2.num.IsZero() {
+			return Value{}, false
+		}
+
+		if op == opDiv {
+			return NumberValue(divNumbers(val1.num, val2.num)), true
+		}
+
+		return NumberValue(val1.num.Mod(val2.num)), true
+	case opEq:
+		return BoolValue(cmpNumbers(val1.num, val2.num) == 0), true
+	case opNe:
+		return BoolValue(cmpNumbers(val1.num, val2.num) != 0), true
+	case opLt:
+		return BoolValue(cmpNumbers(val1.num, val2.num) < 0), true
+	case opLe:
+		return BoolValue(cmpNumbers(val1.num, val2.num) <= 0), true
+	case opGt:
+		return BoolValue(cmpNumbers(val1.num, val2.num) > 0), true
+	case opGe:
+		return BoolValue(cmpNumbers(val1.num, val2.num) >= 0), true
+	case opCoalesce:
+		return val1, true
+	default:
+		return Value{}, false
+	}
+}